@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/aprokopczyk/mergemate/ui/context"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mergeRequestRecord is a merge request printed by commands.
+type mergeRequestRecord struct {
+	Project      string `json:"project"`
+	Iid          int    `json:"iid"`
+	Title        string `json:"title"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	State        string `json:"state"`
+	MergeStatus  string `json:"merge_status"`
+	WebUrl       string `json:"web_url"`
+}
+
+type branchRecord struct {
+	Project      string    `json:"project"`
+	Name         string    `json:"name"`
+	LastCommitAt time.Time `json:"last_commit_at"`
+	LastCommit   string    `json:"last_commit"`
+}
+
+// commandProjects are projects commands work on, projects of groups are created when they are asked for.
+type commandProjects struct {
+	projects  []*context.Project
+	groups    []*context.Group
+	factories []*projectFactory
+}
+
+// runCommand runs commands used in scripts: mr list, mr create, mr automerge, mr rebase, branch list and watch.
+func runCommand(out io.Writer, options options, args []string) error {
+	err := checkOutput(options.output)
+	if err != nil {
+		return err
+	}
+	if args[0] == "watch" {
+		if len(args) != 2 {
+			return usageError("usage: mergemate watch <iid>")
+		}
+		iid, err := parseIid(args[1])
+		if err != nil {
+			return err
+		}
+		return watchMergeRequest(out, options, iid)
+	}
+	if len(args) < 2 {
+		return usageError("unknown command '%v'", strings.Join(args, " "))
+	}
+	command := strings.Join(args[:2], " ")
+	args = args[2:]
+	switch command {
+	case "mr list":
+		if options.state != "opened" && options.state != "merged" {
+			return usageError("unknown merge request state %v, use opened or merged", options.state)
+		}
+		return withoutArgs(args, func() error { return listMergeRequests(out, options) })
+	case "mr create":
+		if options.source == "" || options.target == "" {
+			return usageError("mr create needs --source and --target branch")
+		}
+		return withoutArgs(args, func() error { return createMergeRequest(out, options) })
+	case "mr automerge":
+		if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+			return usageError("usage: mergemate mr automerge <iid> on|off")
+		}
+		iid, err := parseIid(args[0])
+		if err != nil {
+			return err
+		}
+		return mergeAutomatically(out, options, iid, args[1] == "on")
+	case "mr rebase":
+		if len(args) != 1 {
+			return usageError("usage: mergemate mr rebase <iid>")
+		}
+		iid, err := parseIid(args[0])
+		if err != nil {
+			return err
+		}
+		return rebaseMergeRequest(out, options, iid)
+	case "branch list":
+		return withoutArgs(args, func() error { return listBranches(out, options) })
+	}
+	return usageError("unknown command '%v'", command)
+}
+
+func withoutArgs(args []string, run func() error) error {
+	if len(args) > 0 {
+		return usageError("unexpected arguments: %v", strings.Join(args, " "))
+	}
+	return run()
+}
+
+func parseIid(value string) (int, error) {
+	iid, err := strconv.Atoi(value)
+	if err != nil || iid <= 0 {
+		return 0, usageError("merge request iid should be a positive number, got %v", value)
+	}
+	return iid, nil
+}
+
+// loadCommandProjects creates clients of configured projects and groups of all profiles, listeners get events of merge engines.
+func loadCommandProjects(options options, listeners []engine.Listener) (*commandProjects, error) {
+	profiles, err := loadProfiles(options)
+	if err != nil {
+		return nil, configError(err)
+	}
+	result := &commandProjects{}
+	for _, profile := range profiles {
+		factory, err := newProjectFactory(profile.config, profile.session, listeners, profile.config.MergeJobIntervalSeconds, profile.prefix)
+		if err != nil {
+			return nil, configError(fmt.Errorf("profile %v: %w", profile.name, err))
+		}
+		result.factories = append(result.factories, factory)
+		for _, path := range projectNames(profile.config) {
+			result.projects = append(result.projects, factory.newProject(path))
+		}
+		for _, group := range groupNames(profile.config) {
+			result.groups = append(result.groups, factory.newGroup(group))
+		}
+	}
+	return result, nil
+}
+
+// project returns project given with --project, it can be omitted when a single project is configured. Projects which
+// aren't configured can be used when groups are configured, as they can belong to one of them.
+func (c *commandProjects) project(name string) (*context.Project, error) {
+	if name == "" {
+		if len(c.projects) != 1 {
+			return nil, usageError("--project is needed, configured projects: %v", strings.Join(c.names(), ", "))
+		}
+		name = c.projects[0].Name
+	}
+	for _, factory := range c.factories {
+		if path, known := factory.paths[name]; known {
+			return withRepositoryConfig(factory, path), nil
+		}
+	}
+	for _, factory := range c.factories {
+		path := name
+		if factory.prefix != "" {
+			path = strings.TrimPrefix(name, factory.prefix+":")
+		}
+		if len(groupNames(factory.config)) > 0 && context.ProjectName(factory.prefix, path) == name {
+			return withRepositoryConfig(factory, path), nil
+		}
+	}
+	return nil, usageError("project %v is not configured, configured projects: %v", name, strings.Join(c.names(), ", "))
+}
+
+// factory returns factory which created the project.
+func (c *commandProjects) factory(project *context.Project) *projectFactory {
+	for _, factory := range c.factories {
+		if _, known := factory.paths[project.Name]; known {
+			return factory
+		}
+	}
+	return nil
+}
+
+// withRepositoryConfig creates project once its repository config is read, so that its policies are applied.
+func withRepositoryConfig(factory *projectFactory, path string) *context.Project {
+	for _, err := range factory.readRepositoryConfigs([]string{path}) {
+		log.Printf("Error when reading repository config of project %v, only your config is used: %v", path, err)
+	}
+	return factory.newProject(path)
+}
+
+// selected returns project given with --project or all configured projects.
+func (c *commandProjects) selected(name string) ([]*context.Project, error) {
+	if name == "" {
+		return c.projects, nil
+	}
+	project, err := c.project(name)
+	if err != nil {
+		return nil, err
+	}
+	return []*context.Project{project}, nil
+}
+
+func (c *commandProjects) names() []string {
+	var names []string
+	for _, project := range c.projects {
+		names = append(names, project.Name)
+	}
+	return names
+}
+
+func listMergeRequests(out io.Writer, options options) error {
+	projects, err := loadCommandProjects(options, nil)
+	if err != nil {
+		return err
+	}
+	selected, err := projects.selected(options.project)
+	if err != nil {
+		return err
+	}
+	var mergeRequests []mergeRequestRecord
+	for _, project := range selected {
+		list, err := project.GitlabClient.ListMergeRequests(options.state)
+		if err != nil {
+			return fmt.Errorf("listing merge requests of %v failed: %w", project.Name, err)
+		}
+		for _, mergeRequest := range list {
+			mergeRequests = append(mergeRequests, newMergeRequestRecord(project.Name, mergeRequest))
+		}
+	}
+	if options.project == "" {
+		listed := make(map[string]bool)
+		for _, project := range selected {
+			listed[project.Name] = true
+		}
+		for _, group := range projects.groups {
+			list, err := group.GitlabClient.ListMergeRequests(options.state)
+			if err != nil {
+				return fmt.Errorf("listing merge requests of group %v failed: %w", group.Name, err)
+			}
+			for _, mergeRequest := range list {
+				name := context.ProjectName(group.Prefix, mergeRequest.ProjectPath())
+				// configured projects which belong to the group are already listed
+				if !listed[name] {
+					mergeRequests = append(mergeRequests, newMergeRequestRecord(name, mergeRequest))
+				}
+			}
+		}
+	}
+	sort.SliceStable(mergeRequests, func(i, j int) bool {
+		if mergeRequests[i].Project != mergeRequests[j].Project {
+			return mergeRequests[i].Project < mergeRequests[j].Project
+		}
+		return mergeRequests[i].Iid < mergeRequests[j].Iid
+	})
+	return writeMergeRequests(out, options.output, mergeRequests)
+}
+
+func createMergeRequest(out io.Writer, options options) error {
+	projects, err := loadCommandProjects(options, nil)
+	if err != nil {
+		return err
+	}
+	project, err := projects.project(options.project)
+	if err != nil {
+		return err
+	}
+	client := project.GitlabClient
+	title := options.title
+	if title == "" {
+		// title is taken from the last commit, as in the user interface
+		branch, err := findBranch(client, options.source)
+		if err != nil {
+			return err
+		}
+		title = branch.Commit.Message
+	}
+	mergeRequest, err := client.CreateMergeRequest(options.source, options.target, title)
+	if errors.Is(err, gitlab.MergeRequestAlreadyExists) {
+		return fmt.Errorf("merge request from branch %v already exists", options.source)
+	} else if err != nil {
+		return fmt.Errorf("creating merge request failed: %w", err)
+	}
+	if options.automerge {
+		_, err = client.CreateMergeRequestNote(mergeRequest.Iid, engine.MergeAutomatically)
+		if err != nil {
+			return fmt.Errorf("merge request !%v was created, but marking it to be merged automatically failed: %w", mergeRequest.Iid, err)
+		}
+	}
+	return writeMergeRequests(out, options.output, []mergeRequestRecord{newMergeRequestRecord(project.Name, *mergeRequest)})
+}
+
+func findBranch(client *gitlab.ApiClient, name string) (*gitlab.Branch, error) {
+	branches, err := client.FetchBranchesWithPattern([]string{name})
+	if err != nil {
+		return nil, fmt.Errorf("fetching branch %v failed: %w", name, err)
+	}
+	for _, branch := range branches {
+		if branch.Name == name {
+			return &branch, nil
+		}
+	}
+	return nil, fmt.Errorf("branch %v doesn't exist", name)
+}
+
+// mergeAutomatically writes the same notes as the user interface and merge request comments, merge job of any running
+// mergemate picks them up.
+func mergeAutomatically(out io.Writer, options options, iid int, enabled bool) error {
+	projects, err := loadCommandProjects(options, nil)
+	if err != nil {
+		return err
+	}
+	project, err := projects.project(options.project)
+	if err != nil {
+		return err
+	}
+	mergeRequest, err := project.GitlabClient.GetMergeRequestDetails(iid)
+	if err != nil {
+		return fmt.Errorf("fetching merge request !%v failed: %w", iid, err)
+	}
+	note := engine.MergeAutomatically
+	if !enabled {
+		note = engine.CancelMergeAutomatically
+	}
+	_, err = project.GitlabClient.CreateMergeRequestNote(iid, note)
+	if err != nil {
+		return fmt.Errorf("changing automatic merge of !%v failed: %w", iid, err)
+	}
+	return writeChangedMergeRequest(out, options, project, mergeRequest)
+}
+
+func rebaseMergeRequest(out io.Writer, options options, iid int) error {
+	projects, err := loadCommandProjects(options, nil)
+	if err != nil {
+		return err
+	}
+	project, err := projects.project(options.project)
+	if err != nil {
+		return err
+	}
+	mergeRequest, err := project.GitlabClient.GetMergeRequestDetails(iid)
+	if err != nil {
+		return fmt.Errorf("fetching merge request !%v failed: %w", iid, err)
+	}
+	err = project.MergeEngine.Rebase(mergeRequest)
+	if err != nil {
+		return fmt.Errorf("rebasing merge request !%v failed: %w", iid, err)
+	}
+	return writeChangedMergeRequest(out, options, project, mergeRequest)
+}
+
+// writeChangedMergeRequest prints merge request fetched again after it was changed, details fetched before the change
+// are printed when it can't be fetched, as the change was already made.
+func writeChangedMergeRequest(out io.Writer, options options, project *context.Project, before *gitlab.MergeRequestDetails) error {
+	mergeRequest, err := project.GitlabClient.GetMergeRequestDetails(before.Iid)
+	if err != nil {
+		log.Printf("Error when fetching merge request !%v after the change, printing details fetched before: %v", before.Iid, err)
+		mergeRequest = before
+	}
+	return writeMergeRequests(out, options.output, []mergeRequestRecord{newMergeRequestRecord(project.Name, *mergeRequest)})
+}
+
+// listBranches lists your branches, branches of projects found in groups are listed only when project is given.
+func listBranches(out io.Writer, options options) error {
+	projects, err := loadCommandProjects(options, nil)
+	if err != nil {
+		return err
+	}
+	selected, err := projects.selected(options.project)
+	if err != nil {
+		return err
+	}
+	var branches []branchRecord
+	for _, project := range selected {
+		list, err := project.GitlabClient.FetchBranchesWithPattern([]string{project.UserBranchPrefix})
+		if err != nil {
+			return fmt.Errorf("listing branches of %v failed: %w", project.Name, err)
+		}
+		for _, branch := range list {
+			branches = append(branches, branchRecord{
+				Project:      project.Name,
+				Name:         branch.Name,
+				LastCommitAt: branch.Commit.AuthoredDate,
+				LastCommit:   strings.SplitN(branch.Commit.Message, "\n", 2)[0],
+			})
+		}
+	}
+	records := records{columns: []string{"project", "name", "last_commit_at", "last_commit"}, values: branches}
+	for _, branch := range branches {
+		records.rows = append(records.rows, []string{branch.Project, branch.Name, branch.LastCommitAt.Format(time.RFC3339), branch.LastCommit})
+	}
+	if branches == nil {
+		records.values = []branchRecord{}
+	}
+	return writeRecords(out, options.output, records)
+}
+
+func newMergeRequestRecord(project string, mergeRequest gitlab.MergeRequestDetails) mergeRequestRecord {
+	return mergeRequestRecord{
+		Project:      project,
+		Iid:          mergeRequest.Iid,
+		Title:        mergeRequest.Title,
+		SourceBranch: mergeRequest.SourceBranch,
+		TargetBranch: mergeRequest.TargetBranch,
+		State:        mergeRequest.State,
+		MergeStatus:  mergeRequest.DetailedMergeStatus,
+		WebUrl:       mergeRequest.WebUrl,
+	}
+}
+
+func writeMergeRequests(out io.Writer, format string, mergeRequests []mergeRequestRecord) error {
+	records := records{
+		columns: []string{"project", "iid", "title", "source_branch", "target_branch", "state", "merge_status", "web_url"},
+		values:  mergeRequests,
+	}
+	if mergeRequests == nil {
+		records.values = []mergeRequestRecord{}
+	}
+	for _, mergeRequest := range mergeRequests {
+		records.rows = append(records.rows, []string{mergeRequest.Project, strconv.Itoa(mergeRequest.Iid), mergeRequest.Title,
+			mergeRequest.SourceBranch, mergeRequest.TargetBranch, mergeRequest.State, mergeRequest.MergeStatus, mergeRequest.WebUrl})
+	}
+	return writeRecords(out, format, records)
+}
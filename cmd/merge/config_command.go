@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"github.com/knadh/koanf"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// secretKeys hold values which are never printed.
+var secretKeys = []string{"TOKEN", "PASSWORD", "SECRET"}
+
+// referenceSuffixes mark keys which only point to a secret, i.e. MERGEMATE_API_TOKEN_FILE.
+var referenceSuffixes = []string{"_FILE", "_COMMAND"}
+
+const redactedValue = "<redacted>"
+
+// showConfig prints effective config of every profile together with the layer each value comes from.
+func showConfig(out io.Writer, options options) error {
+	profiles, err := readProfiles(options)
+	if err != nil {
+		return configError(err)
+	}
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for i, profile := range profiles {
+		if i > 0 {
+			fmt.Fprintln(writer)
+		}
+		fmt.Fprintf(writer, "Profile %v\n", profile.name)
+		merged := koanf.New(".")
+		for _, layer := range profile.layers {
+			err = merged.Merge(layer.values)
+			if err != nil {
+				return err
+			}
+		}
+		for _, key := range configKeys() {
+			fmt.Fprintf(writer, "%v\t%v\t%v\n", key, entrySource(profile, key), displayValue(key, merged.Get(key)))
+		}
+		showProjectSettings(writer, profile)
+	}
+	return writer.Flush()
+}
+
+// showProjectSettings prints effective settings of every project, repository config of projects is read when
+// config is valid.
+func showProjectSettings(out io.Writer, profile profile) {
+	config := *profile.config
+	session, err := resolveToken(&config)
+	if err == nil {
+		err = validateConfig(&config)
+	}
+	var factory *projectFactory
+	if err == nil {
+		factory, err = newProjectFactory(&config, session, nil, config.MergeJobIntervalSeconds, "")
+	}
+	if err != nil {
+		fmt.Fprintf(out, "Config is not valid: %v.\n", err)
+		return
+	}
+	redactSecrets(&config)
+	repositoryErrors := factory.readRepositoryConfigs(projectNames(&config))
+	for _, path := range projectNames(&config) {
+		fmt.Fprintf(out, "\nProject %v\n", path)
+		if err := repositoryErrors[path]; err != nil {
+			fmt.Fprintf(out, "Repository config couldn't be read: %v\n", err)
+		}
+		repository := factory.repositories[path]
+		project, sources := factory.effectiveSettings(path)
+		source := func(entry string) string {
+			switch entry {
+			case "":
+				return "not set"
+			case repositoryConfigFile:
+				return repositorySource(repository)
+			}
+			return fmt.Sprintf("%v (%v)", entry, entrySource(profile, entry))
+		}
+		var favourites []string
+		for _, favourite := range project.FavouriteBranches {
+			if favourite.Branch != "" {
+				favourites = append(favourites, favourite.Branch)
+			}
+		}
+		fmt.Fprintf(out, "branch_prefix\t%v\t%v\n", source(sources.branchPrefix), project.UserBranchPrefix)
+		fmt.Fprintf(out, "target_branch_prefixes\t%v\t%v\n", source(sources.targetBranchPrefixes), strings.Join(project.TargetBranchPrefixes, ","))
+		fmt.Fprintf(out, "favorite_branches\t%v\t%v\n", source(sources.favouriteBranches), strings.Join(favourites, ","))
+		policies, policySources := projectPolicies(factory.projectConfig(path), &config, repository)
+		for i, policy := range policies {
+			fmt.Fprintf(out, "policy %v\t%v\t%v\n", policy.TargetBranch, source(policySources[i]), policyDescription(policy))
+		}
+	}
+}
+
+// entrySource returns the last layer setting given config entry.
+func entrySource(profile profile, key string) string {
+	source := "not set"
+	for _, layer := range profile.layers {
+		if layer.values.Exists(key) {
+			source = layer.source
+		}
+	}
+	return source
+}
+
+func policyDescription(policy PolicyConfig) string {
+	rebase, skipCi := true, true
+	if policy.Rebase != nil {
+		rebase = *policy.Rebase
+	}
+	if policy.SkipCi != nil {
+		skipCi = *policy.SkipCi
+	}
+	return fmt.Sprintf("rebase=%v skip_ci=%v", rebase, skipCi)
+}
+
+// configKeys lists keys of AppConfig in order of declaration.
+func configKeys() []string {
+	var keys []string
+	configType := reflect.TypeOf(AppConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		keys = append(keys, configType.Field(i).Tag.Get("koanf"))
+	}
+	return keys
+}
+
+func displayValue(key string, value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if isSecretKey(key) && fmt.Sprint(value) != "" {
+		return redactedValue
+	}
+	return fmt.Sprint(value)
+}
+
+func isSecretKey(key string) bool {
+	for _, suffix := range referenceSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return false
+		}
+	}
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecrets hides secret values of config in log output.
+func redactSecrets(config *AppConfig) {
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if isSecretKey(value.Type().Field(i).Tag.Get("koanf")) && value.Field(i).Kind() == reflect.String {
+			logRedactor.addSecret(value.Field(i).String())
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/adrg/xdg"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"github.com/aprokopczyk/mergemate/ui/keys"
+	"github.com/aprokopczyk/mergemate/ui/styles"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/dotenv"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/providers/file"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// configFiles are looked for in mergemate config dir in this order, the first one found is used.
+var configFiles = []string{"mergemate_config.yaml", "mergemate_config.yml", "mergemate_config.toml", "mergemate_config.env"}
+
+// structuredSections are kept nested when structured config file is loaded, other keys are translated to MERGEMATE_ keys.
+var structuredSections = map[string]bool{"projects": true, "policies": true, "keys": true, "theme": true}
+
+type ProjectConfig struct {
+	Name                 string                 `koanf:"name"`
+	BranchPrefix         string                 `koanf:"branch_prefix"`
+	TargetBranchPrefixes []string               `koanf:"target_branch_prefixes"`
+	FavoriteBranches     []FavoriteBranchConfig `koanf:"favorite_branches"`
+	Policies             []PolicyConfig         `koanf:"policies"`
+}
+
+type FavoriteBranchConfig struct {
+	Branch string `koanf:"branch"`
+	Key    string `koanf:"key"`
+}
+
+type PolicyConfig struct {
+	TargetBranch string `koanf:"target_branch"`
+	Rebase       *bool  `koanf:"rebase"`
+	SkipCi       *bool  `koanf:"skip_ci"`
+}
+
+type ThemeConfig struct {
+	Primary   string `koanf:"primary"`
+	Secondary string `koanf:"secondary"`
+	Warning   string `koanf:"warning"`
+}
+
+// configFile holds values of config file, values are empty when there is no config file.
+type configFile struct {
+	path   string
+	values *koanf.Koanf
+}
+
+// findConfigFile returns path of config file in mergemate config dir, path is empty when there is none.
+func findConfigFile() (string, error) {
+	for _, name := range configFiles {
+		path := filepath.Join(xdg.ConfigHome, mergeMateDir, name)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// loadConfigFile loads file given with --config or the one found in mergemate config dir, config file is optional
+// unless it's given explicitly.
+func loadConfigFile(configFilePath string) (configFile, error) {
+	var err error
+	if configFilePath == "" {
+		configFilePath, err = findConfigFile()
+		if err != nil {
+			return configFile{}, err
+		}
+	}
+	if configFilePath == "" {
+		log.Printf("No config file found, using only environment variables and flags")
+		return configFile{values: koanf.New(".")}, nil
+	}
+	log.Printf("Loading config file %v", configFilePath)
+
+	k := koanf.New(".")
+	switch filepath.Ext(configFilePath) {
+	case ".env":
+		// keys with profile name and a dot are unflattened into profile sections
+		err = k.Load(file.Provider(configFilePath), dotenv.ParserEnv("", ".", func(s string) string { return s }))
+		return configFile{path: configFilePath, values: k}, err
+	case ".toml":
+		err = k.Load(file.Provider(configFilePath), toml.Parser())
+	case ".yaml", ".yml":
+		err = k.Load(file.Provider(configFilePath), yaml.Parser())
+	default:
+		return configFile{}, fmt.Errorf("config file %v should have one of extensions: .yaml, .yml, .toml, .env", configFilePath)
+	}
+	if err != nil {
+		return configFile{}, err
+	}
+	values, err := translateStructured(k.Raw())
+	if err != nil {
+		return configFile{}, fmt.Errorf("%v: %w", configFilePath, err)
+	}
+	translated := koanf.New(".")
+	err = translated.Load(confmap.Provider(values, ""), nil)
+	return configFile{path: configFilePath, values: translated}, err
+}
+
+// translateStructured turns keys of structured config file into keys used by .env file and environment variables,
+// i.e. gitlab_url becomes MERGEMATE_GITLAB_URL and lists become comma separated values.
+func translateStructured(values map[string]interface{}) (map[string]interface{}, error) {
+	translated := make(map[string]interface{})
+	for key, value := range values {
+		switch {
+		case key == "profiles":
+			profiles, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("profiles should be a section with a section per profile")
+			}
+			for name, profileValues := range profiles {
+				section, ok := profileValues.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("profile %v should be a section", name)
+				}
+				translatedProfile, err := translateStructured(section)
+				if err != nil {
+					return nil, fmt.Errorf("profile %v: %w", name, err)
+				}
+				translated[name] = translatedProfile
+			}
+		case structuredSections[key]:
+			translated[key] = value
+		default:
+			flat, err := flatValue(key, value)
+			if err != nil {
+				return nil, err
+			}
+			translated["MERGEMATE_"+strings.ToUpper(key)] = flat
+		}
+	}
+	return translated, nil
+}
+
+func flatValue(key string, value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		return nil, fmt.Errorf("unknown section %v", key)
+	default:
+		return value, nil
+	}
+}
+
+// theme overrides colors of default theme with colors given in config.
+func theme(config ThemeConfig) styles.Theme {
+	theme := styles.DefaultTheme()
+	if config.Primary != "" {
+		theme.Primary = lipgloss.Color(config.Primary)
+	}
+	if config.Secondary != "" {
+		theme.Secondary = lipgloss.Color(config.Secondary)
+	}
+	if config.Warning != "" {
+		theme.Warning = lipgloss.Color(config.Warning)
+	}
+	return theme
+}
+
+// policies converts policies from config, policy of a project is checked before policies of all projects,
+// policies of the repository are checked last.
+func policies(project ProjectConfig, config *AppConfig, repository RepositoryConfig) []engine.Policy {
+	var result []engine.Policy
+	configs, _ := projectPolicies(project, config, repository)
+	for _, policy := range configs {
+		converted := engine.Policy{TargetBranch: policy.TargetBranch, Rebase: true, SkipCi: true}
+		if policy.Rebase != nil {
+			converted.Rebase = *policy.Rebase
+		}
+		if policy.SkipCi != nil {
+			converted.SkipCi = *policy.SkipCi
+		}
+		result = append(result, converted)
+	}
+	return result
+}
+
+// projectPolicies lists policies in the order they are checked together with config entries they come from.
+func projectPolicies(project ProjectConfig, config *AppConfig, repository RepositoryConfig) ([]PolicyConfig, []string) {
+	var result []PolicyConfig
+	var sources []string
+	for _, policies := range []struct {
+		policies []PolicyConfig
+		source   string
+	}{{project.Policies, "projects"}, {config.Policies, "policies"}, {repository.Policies, repositoryConfigFile}} {
+		for _, policy := range policies.policies {
+			result = append(result, policy)
+			sources = append(sources, policies.source)
+		}
+	}
+	return result, sources
+}
+
+func validatePolicies(config *AppConfig) error {
+	all := config.Policies
+	for _, project := range config.Projects {
+		if project.Name == "" {
+			return errors.New("every entry of projects section needs a name")
+		}
+		all = append(append([]PolicyConfig(nil), all...), project.Policies...)
+	}
+	return validatePolicyPatterns(all)
+}
+
+// validateFavourites checks that keys of favourite branches don't shadow keys of actions, per project favourite
+// branches are checked when they are parsed.
+func validateFavourites(config *AppConfig) error {
+	err := keys.CheckFavourites(favourites(strings.Split(config.FavouriteBranches, ",")), config.Keys)
+	if err != nil {
+		return fmt.Errorf("MERGEMATE_FAVORITE_BRANCHES: %w", err)
+	}
+	for _, project := range config.Projects {
+		err = keys.CheckFavourites(favouritesOf(project.FavoriteBranches), config.Keys)
+		if err != nil {
+			return fmt.Errorf("projects section, project %v: %w", project.Name, err)
+		}
+	}
+	return nil
+}
+
+func validatePolicyPatterns(policies []PolicyConfig) error {
+	for _, policy := range policies {
+		if policy.TargetBranch == "" {
+			return errors.New("every policy needs target_branch pattern")
+		}
+		if _, err := path.Match(policy.TargetBranch, ""); err != nil {
+			return fmt.Errorf("invalid target_branch pattern %v of policy: %w", policy.TargetBranch, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/adrg/xdg"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/aprokopczyk/mergemate/ui/setup"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/dotenv"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/parsers/yaml"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// initConfig asks for required config values and writes them to file given with --config, to config file found in
+// mergemate config dir, so it's the one read afterwards, or to a new .env file there.
+func initConfig(out io.Writer, options options) error {
+	path := options.configPath
+	if path == "" {
+		found, err := findConfigFile()
+		if err != nil {
+			return err
+		}
+		path = found
+	}
+	if path == "" {
+		path = filepath.Join(xdg.ConfigHome, mergeMateDir, configFiles[len(configFiles)-1])
+	}
+	_, err := os.Stat(path)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	transport, err := initTransport(options)
+	if err != nil {
+		return err
+	}
+	wizard := setup.New(path, exists, transport)
+	_, err = tea.NewProgram(wizard).Run()
+	if err != nil {
+		return err
+	}
+	if !wizard.Done {
+		fmt.Fprintln(out, "Config was not written.")
+		return nil
+	}
+	err = writeConfigFile(path, initialValues(wizard.Result()))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Config written to %v.\n", path)
+	return nil
+}
+
+// initTransport uses connection settings given with environment variables, flags or existing config file, defaults
+// are used when existing config file can't be read.
+func initTransport(options options) (*gitlab.Transport, error) {
+	profiles, err := readProfiles(options)
+	if err != nil {
+		log.Printf("Using default connection settings, config can't be read: %v", err)
+		return gitlab.NewTransport(gitlab.TransportConfig{})
+	}
+	return newTransport(profiles[0].config)
+}
+
+func initialValues(result setup.Result) map[string]interface{} {
+	values := map[string]interface{}{
+		"MERGEMATE_GITLAB_URL":        result.GitlabUrl,
+		"MERGEMATE_API_TOKEN":         result.ApiToken,
+		"MERGEMATE_USER_NAME":         result.UserName,
+		"MERGEMATE_PROJECT_NAME":      result.ProjectName,
+		"MERGEMATE_SLB_BRANCH_PREFIX": result.BranchPrefix,
+	}
+	if result.DefaultBranch != "" {
+		values["MERGEMATE_TARGET_BRANCH_PREFIXES"] = result.DefaultBranch
+	}
+	return values
+}
+
+// writeConfigFile writes values in format given by file extension, other entries of existing file, i.e. profiles or
+// notifications, are kept. File is readable only by its owner as it holds the token.
+func writeConfigFile(path string, values map[string]interface{}) error {
+	var parser koanf.Parser
+	switch filepath.Ext(path) {
+	case ".env":
+		parser = dotenv.Parser()
+	case ".toml":
+		parser = toml.Parser()
+		values = structuredValues(values)
+	case ".yaml", ".yml":
+		parser = yaml.Parser()
+		values = structuredValues(values)
+	default:
+		return fmt.Errorf("config file %v should have one of extensions: .yaml, .yml, .toml, .env", path)
+	}
+	merged, err := readExistingConfig(path, parser)
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		merged[key] = value
+	}
+	content, err := parser.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, content, 0600)
+	if err != nil {
+		return err
+	}
+	// permissions of existing file are not changed by WriteFile
+	return os.Chmod(path, 0600)
+}
+
+// readExistingConfig returns entries of config file as they are written in it, nothing is returned when there is no file.
+func readExistingConfig(path string, parser koanf.Parser) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string]interface{}), nil
+	} else if err != nil {
+		return nil, err
+	}
+	values, err := parser.Unmarshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("existing config file %v can't be read, it wasn't changed: %w", path, err)
+	}
+	return values, nil
+}
+
+// structuredValues turns MERGEMATE_ keys into keys of structured config file, i.e. MERGEMATE_GITLAB_URL becomes gitlab_url.
+func structuredValues(values map[string]interface{}) map[string]interface{} {
+	structured := make(map[string]interface{})
+	for key, value := range values {
+		structured[strings.ToLower(strings.TrimPrefix(key, envPrefix))] = value
+	}
+	return structured
+}
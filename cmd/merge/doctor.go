@@ -0,0 +1,406 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/aprokopczyk/mergemate/ui/context"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	checkPassed  = "pass"
+	checkFailed  = "fail"
+	checkSkipped = "skip"
+	// checkWarning reports risky config, it doesn't fail the diagnosis
+	checkWarning = "warn"
+)
+
+// requiredScope allows the token to create, rebase and merge merge requests.
+const requiredScope = "api"
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+type check struct {
+	Profile string `json:"profile"`
+	Project string `json:"project,omitempty"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// diagnosis collects results of checks, checks are attributed to profile and project being checked.
+type diagnosis struct {
+	checks  []check
+	profile string
+	project string
+}
+
+func (d *diagnosis) add(name string, status string, detail string) {
+	d.checks = append(d.checks, check{Profile: d.profile, Project: d.project, Name: name, Status: status, Detail: detail})
+}
+
+func (d *diagnosis) pass(name string, format string, args ...interface{}) {
+	d.add(name, checkPassed, fmt.Sprintf(format, args...))
+}
+
+func (d *diagnosis) fail(name string, err error) {
+	d.add(name, checkFailed, err.Error())
+}
+
+func (d *diagnosis) skip(name string, reason string) {
+	d.add(name, checkSkipped, reason)
+}
+
+func (d *diagnosis) warn(name string, reason string) {
+	d.add(name, checkWarning, reason)
+}
+
+func (d *diagnosis) count(status string) int {
+	count := 0
+	for _, check := range d.checks {
+		if check.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// runDoctor checks config of every profile, connection to gitlab, the token and permissions in configured projects.
+func runDoctor(out io.Writer, options options) error {
+	d := &diagnosis{profile: defaultProfile}
+	profiles, err := readProfiles(options)
+	if err != nil {
+		if len(options.profiles) > 0 {
+			d.profile = strings.Join(options.profiles, ",")
+		}
+		d.fail("config", err)
+	}
+	for _, profile := range profiles {
+		d.profile = profile.name
+		diagnoseProfile(d, profile.config)
+	}
+
+	if options.json {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(struct {
+			Passed bool    `json:"passed"`
+			Checks []check `json:"checks"`
+		}{Passed: d.count(checkFailed) == 0, Checks: d.checks})
+	} else {
+		err = printDiagnosis(out, d)
+	}
+	if err != nil {
+		return err
+	}
+	if failed := d.count(checkFailed); failed > 0 {
+		return fmt.Errorf("%v of %v checks failed", failed, len(d.checks))
+	}
+	return nil
+}
+
+func printDiagnosis(out io.Writer, d *diagnosis) error {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	profile := ""
+	for _, check := range d.checks {
+		if check.Profile != profile {
+			if profile != "" {
+				fmt.Fprintln(writer)
+			}
+			profile = check.Profile
+			fmt.Fprintf(writer, "Profile %v\n", profile)
+		}
+		name := check.Name
+		if check.Project != "" {
+			name = check.Project + ": " + name
+		}
+		fmt.Fprintf(writer, "  %v\t%v\t%v\n", strings.ToUpper(check.Status), name, check.Detail)
+	}
+	fmt.Fprintf(writer, "\n%v passed, %v failed, %v skipped, %v warnings\n", d.count(checkPassed), d.count(checkFailed),
+		d.count(checkSkipped), d.count(checkWarning))
+	return writer.Flush()
+}
+
+func diagnoseProfile(d *diagnosis, config *AppConfig) {
+	d.project = ""
+	session, err := resolveToken(config)
+	if err == nil {
+		err = validateConfig(config)
+	}
+	var factory *projectFactory
+	if err == nil {
+		factory, err = newProjectFactory(config, session, nil, config.MergeJobIntervalSeconds, "")
+	}
+	if err != nil {
+		d.fail("config", err)
+	} else {
+		d.pass("config", "")
+	}
+	redactSecrets(config)
+	if config.GitlabUrl == "" {
+		d.skip("connection", "gitlab URL is not configured")
+		return
+	}
+
+	transport, err := newTransport(config)
+	if err != nil {
+		d.fail("connection settings", err)
+		return
+	}
+	diagnoseTransport(d, config, transport)
+	client := configureClient(gitlab.New(config.GitlabUrl, "", config.UserName, config.ApiToken), transport, session)
+	if !diagnoseConnection(d, client, transport) {
+		return
+	}
+	user := diagnoseToken(d, config, client, session != nil)
+	if user == nil {
+		return
+	}
+	if factory == nil {
+		d.skip("projects", "config is not valid")
+		return
+	}
+	for _, group := range groupNames(config) {
+		d.project = group
+		err = factory.newGroup(group).GitlabClient.CheckGroup()
+		if err != nil {
+			d.fail("group access", err)
+		} else {
+			d.pass("group access", "")
+		}
+	}
+	repositoryErrors := factory.readRepositoryConfigs(projectNames(config))
+	for _, path := range projectNames(config) {
+		d.project = path
+		diagnoseProject(d, factory.newProject(path), user)
+		diagnoseRepositoryConfig(d, factory.repositories[path], repositoryErrors[path])
+	}
+}
+
+func diagnoseRepositoryConfig(d *diagnosis, repository RepositoryConfig, err error) {
+	switch {
+	case err != nil:
+		d.warn("repository config", fmt.Sprintf("only your config is used, %v", err))
+	case repository.Branch == "":
+		d.pass("repository config", "project has no %v", repositoryConfigFile)
+	default:
+		d.pass("repository config", "read from %v", repositorySource(repository))
+	}
+}
+
+// diagnoseTransport describes TLS, proxy and timeout settings and checks that client certificate is valid.
+func diagnoseTransport(d *diagnosis, config *AppConfig, transport *gitlab.Transport) {
+	var settings []string
+	if config.CaBundle != "" {
+		settings = append(settings, "CA bundle "+config.CaBundle)
+	}
+	if certificate := transport.ClientCertificate; certificate != nil {
+		if time.Now().After(certificate.NotAfter) {
+			d.fail("connection settings", fmt.Errorf("client certificate %v expired on %v", config.ClientCert,
+				certificate.NotAfter.Format("2006-01-02")))
+			return
+		}
+		settings = append(settings, fmt.Sprintf("client certificate of %v valid until %v", certificateName(certificate),
+			certificate.NotAfter.Format("2006-01-02")))
+	}
+	if transport.ProxyUrl() != "" {
+		settings = append(settings, "proxy "+transport.ProxyUrl())
+	}
+	settings = append(settings, fmt.Sprintf("timeout %vs", config.RequestTimeoutSeconds))
+	d.pass("connection settings", strings.Join(settings, ", "))
+}
+
+// diagnoseConnection checks that gitlab responds and its certificate is trusted, false is returned when gitlab can't
+// be reached.
+func diagnoseConnection(d *diagnosis, client *gitlab.ApiClient, transport *gitlab.Transport) bool {
+	connection, err := client.CheckConnection()
+	if err != nil && isTlsError(err) {
+		d.pass("connection", "connected, but secure connection couldn't be established")
+		d.fail("tls", err)
+		return false
+	} else if err != nil {
+		d.fail("connection", err)
+		d.skip("tls", "gitlab is not reachable")
+		return false
+	}
+
+	if connection.Version != "" {
+		d.pass("connection", "gitlab %v", connection.Version)
+	} else {
+		d.pass("connection", "gitlab responds")
+	}
+	if connection.TLS == nil {
+		d.skip("tls", "gitlab is accessed over plain http, the token is sent unencrypted")
+	} else if transport.Insecure() {
+		d.warn("tls", "certificate verification is disabled with MERGEMATE_INSECURE_SKIP_VERIFY, connection can be intercepted")
+	} else if certificates := connection.TLS.PeerCertificates; len(certificates) > 0 {
+		d.pass("tls", "%v, certificate of %v valid until %v", tlsVersions[connection.TLS.Version],
+			certificateName(certificates[0]), certificates[0].NotAfter.Format("2006-01-02"))
+	} else {
+		d.pass("tls", tlsVersions[connection.TLS.Version])
+	}
+	return true
+}
+
+func certificateName(certificate *x509.Certificate) string {
+	if certificate.Subject.CommonName == "" && len(certificate.DNSNames) > 0 {
+		return certificate.DNSNames[0]
+	}
+	return certificate.Subject.CommonName
+}
+
+func isTlsError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		errors.As(err, &recordHeader)
+}
+
+// diagnoseToken checks that the token is accepted, belongs to configured user and has required scope, owner of the
+// token is returned when it's accepted.
+func diagnoseToken(d *diagnosis, config *AppConfig, client *gitlab.ApiClient, oauth bool) *gitlab.User {
+	user, err := client.CurrentUser()
+	if err != nil {
+		d.fail("token", err)
+		return nil
+	}
+	d.pass("token", "token of %v", user.Username)
+	if user.Username != config.UserName {
+		d.fail("user name", fmt.Errorf("token belongs to %v, but MERGEMATE_USER_NAME is %v", user.Username, config.UserName))
+	} else {
+		d.pass("user name", "")
+	}
+
+	if oauth {
+		d.pass("token scopes", "token obtained with login has %v scope", requiredScope)
+		return user
+	}
+	token, err := client.TokenInfo()
+	switch {
+	case err != nil:
+		d.fail("token scopes", err)
+	case token == nil:
+		d.skip("token scopes", "gitlab doesn't describe tokens, version 15.5 or newer is needed")
+	case !token.Active || token.Revoked:
+		d.fail("token scopes", fmt.Errorf("token %v is not active", token.Name))
+	case !contains(token.Scopes, requiredScope):
+		d.fail("token scopes", fmt.Errorf("token has scopes %v, %v scope is needed to create and merge merge requests",
+			strings.Join(token.Scopes, ", "), requiredScope))
+	case token.ExpiresAt != "":
+		d.pass("token scopes", "%v, expires at %v", strings.Join(token.Scopes, ", "), token.ExpiresAt)
+	default:
+		d.pass("token scopes", strings.Join(token.Scopes, ", "))
+	}
+	return user
+}
+
+// diagnoseProject checks access to the project, that configured prefixes match branches and that the user can rebase
+// own branches and merge to target branches.
+func diagnoseProject(d *diagnosis, project *context.Project, user *gitlab.User) {
+	client := project.GitlabClient
+	details, err := client.ProjectDetails()
+	if err != nil {
+		d.fail("project access", err)
+		return
+	}
+	level := details.AccessLevel()
+	if level < gitlab.DeveloperAccess {
+		d.fail("project access", fmt.Errorf("%v access is not enough to push branches and create merge requests, developer access is needed",
+			gitlab.AccessLevelName(level)))
+	} else {
+		d.pass("project access", "%v access", gitlab.AccessLevelName(level))
+	}
+
+	branches, err := client.FetchBranchesWithPattern([]string{project.UserBranchPrefix})
+	if err != nil {
+		d.fail("branch prefix", err)
+	} else if len(branches) == 0 {
+		d.fail("branch prefix", fmt.Errorf("no branch starts with %v", project.UserBranchPrefix))
+	} else {
+		d.pass("branch prefix", "branches starting with %v: %v", project.UserBranchPrefix, len(branches))
+	}
+
+	var targets []gitlab.Branch
+	var unmatched []string
+	for _, prefix := range project.TargetBranchPrefixes {
+		if prefix == "" {
+			continue
+		}
+		found, err := client.FetchBranchesWithPattern([]string{prefix})
+		if err != nil {
+			d.fail("target branch prefixes", err)
+			return
+		}
+		if len(found) == 0 {
+			unmatched = append(unmatched, prefix)
+		}
+		targets = append(targets, found...)
+	}
+	if len(unmatched) > 0 {
+		d.fail("target branch prefixes", fmt.Errorf("no branch starts with %v", strings.Join(unmatched, ", ")))
+	} else if len(targets) == 0 {
+		d.skip("target branch prefixes", "no target branch prefixes are configured")
+	} else {
+		d.pass("target branch prefixes", "target branches: %v", len(targets))
+	}
+
+	protected, err := client.ProtectedBranches()
+	if err != nil {
+		d.fail("permissions", err)
+		return
+	}
+	// rebase pushes to source branch of merge request
+	denied := deniedBranches(branches, protected, level, func(branch gitlab.ProtectedBranch) bool {
+		return branch.AllowsPush(level, user.Id)
+	})
+	reportPermission(d, "rebase permission", "rebase", branches, denied)
+	denied = deniedBranches(targets, protected, level, func(branch gitlab.ProtectedBranch) bool {
+		return branch.AllowsMerge(level, user.Id)
+	})
+	reportPermission(d, "merge permission", "merge to", targets, denied)
+}
+
+// deniedBranches returns branches the user can't change, unprotected branches can be changed by developers and
+// protected ones by anyone allowed by at least one matching protection.
+func deniedBranches(branches []gitlab.Branch, protected []gitlab.ProtectedBranch, level int, allowed func(gitlab.ProtectedBranch) bool) []string {
+	var denied []string
+	for _, branch := range branches {
+		isProtected := false
+		isAllowed := false
+		for _, protection := range protected {
+			if protection.Matches(branch.Name) {
+				isProtected = true
+				isAllowed = isAllowed || allowed(protection)
+			}
+		}
+		if (isProtected && !isAllowed) || (!isProtected && level < gitlab.DeveloperAccess) {
+			denied = append(denied, branch.Name)
+		}
+	}
+	return denied
+}
+
+func reportPermission(d *diagnosis, name string, action string, branches []gitlab.Branch, denied []string) {
+	switch {
+	case len(branches) == 0:
+		d.skip(name, "no branches to check")
+	case len(denied) > 0:
+		d.fail(name, fmt.Errorf("you can't %v %v", action, strings.Join(denied, ", ")))
+	default:
+		d.pass(name, "checked branches: %v", len(branches))
+	}
+}
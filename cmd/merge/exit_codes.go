@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// exit codes of commands, scripts can rely on them
+const (
+	// exitFailed is returned when command couldn't be done, i.e. gitlab rejected a request, doctor found a problem or
+	// watched merge request failed
+	exitFailed = 1
+	// exitUsage is returned for unknown commands, flags and invalid arguments
+	exitUsage = 2
+	// exitConfig is returned when config is not valid
+	exitConfig = 3
+	// exitConflict is returned when watched merge request has conflicts
+	exitConflict = 4
+	// exitTimeout is returned when watched merge request wasn't merged in time
+	exitTimeout = 5
+)
+
+// exitError makes mergemate exit with given code, errors of other types exit with exitFailed.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+func usageError(format string, args ...interface{}) error {
+	return exitError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+func configError(err error) error {
+	return exitError{code: exitConfig, err: err}
+}
+
+func exitCode(err error) int {
+	var exit exitError
+	if errors.As(err, &exit) {
+		return exit.code
+	}
+	return exitFailed
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"github.com/knadh/koanf/providers/posflag"
+	"github.com/spf13/pflag"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const envPrefix = "MERGEMATE_"
+
+// configKeyAnnotation marks flags which set config keys, its value is the key.
+const configKeyAnnotation = "configKey"
+
+const usage = `Usage:
+  mergemate [flags]              start interactive user interface
+  mergemate config show [flags]  print effective config with source of every value
+  mergemate config init [flags]  ask for required values and write config file
+  mergemate doctor [flags]       check config, connection to gitlab, the token and permissions in projects
+  mergemate login [flags]        log in to gitlab with OAuth application instead of using api token
+
+Commands for scripts, they print --output format and exit with 1 when gitlab rejects a request or watched merge
+request fails, 2 on invalid arguments, 3 on invalid config, 4 on conflicts and 5 on timeout:
+  mergemate mr list [--state opened|merged] [--project name]
+  mergemate mr create --source branch --target branch [--title title] [--automerge] [--project name]
+  mergemate mr automerge <iid> on|off [--project name]
+  mergemate mr rebase <iid> [--project name]
+  mergemate branch list [--project name]
+  mergemate watch <iid> [--timeout 30m] [--automerge] [--project name]
+
+Flags:
+`
+
+type options struct {
+	configPath string
+	profiles   []string
+	json       bool
+	device     bool
+	output     string
+	project    string
+	state      string
+	source     string
+	target     string
+	title      string
+	automerge  bool
+	timeout    time.Duration
+	flags      *pflag.FlagSet
+}
+
+// newFlagSet creates flags of commands and a flag for every config key, i.e. --gitlab-url sets MERGEMATE_GITLAB_URL.
+// Secrets have no flags, command line is seen by other users and kept in shell history.
+func newFlagSet() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("mergemate", pflag.ContinueOnError)
+	flags.String("config", "", "Config file used instead of the one in mergemate config dir")
+	flags.String("profile", "", "Comma separated list of config file profiles, several profiles are shown side by side")
+	flags.Bool("json", false, "Print report of doctor command as JSON, same as --output json for other commands")
+	flags.Bool("device", false, "Log in with device flow, a code is entered on gitlab page instead of redirecting to mergemate")
+	flags.String("output", outputTable, "Output format of mr and branch commands: table, json or csv")
+	flags.String("project", "", "Project of mr and branch commands, it can be omitted when a single project is configured")
+	flags.String("state", "opened", "State of merge requests listed by mr list: opened or merged")
+	flags.String("source", "", "Source branch of merge request created by mr create")
+	flags.String("target", "", "Target branch of merge request created by mr create")
+	flags.String("title", "", "Title of merge request created by mr create, last commit message is used by default")
+	flags.Bool("automerge", false, "Merge request created by mr create or waited for by watch is merged automatically")
+	flags.Duration("timeout", 0, "Time watch waits for merge request to be merged, it waits without limit by default")
+	configType := reflect.TypeOf(AppConfig{})
+	for i := 0; i < configType.NumField(); i++ {
+		key := configType.Field(i).Tag.Get("koanf")
+		if !strings.HasPrefix(key, envPrefix) || isSecretKey(key) {
+			continue
+		}
+		name := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(key, envPrefix), "_", "-"))
+		description := "Overrides " + key
+		switch configType.Field(i).Type.Kind() {
+		case reflect.Int:
+			flags.Int(name, 0, description)
+		case reflect.Bool:
+			flags.Bool(name, false, description)
+		default:
+			flags.String(name, "", description)
+		}
+		_ = flags.SetAnnotation(name, configKeyAnnotation, []string{key})
+	}
+	flags.SortFlags = false
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fmt.Fprint(os.Stderr, flags.FlagUsages())
+	}
+	return flags
+}
+
+func parseOptions(flags *pflag.FlagSet) options {
+	configPath, _ := flags.GetString("config")
+	profiles, _ := flags.GetString("profile")
+	json, _ := flags.GetBool("json")
+	device, _ := flags.GetBool("device")
+	output, _ := flags.GetString("output")
+	if json {
+		output = outputJson
+	}
+	project, _ := flags.GetString("project")
+	state, _ := flags.GetString("state")
+	source, _ := flags.GetString("source")
+	target, _ := flags.GetString("target")
+	title, _ := flags.GetString("title")
+	automerge, _ := flags.GetBool("automerge")
+	timeout, _ := flags.GetDuration("timeout")
+	return options{
+		configPath: configPath,
+		profiles:   splitList(profiles),
+		json:       json,
+		device:     device,
+		output:     output,
+		project:    project,
+		state:      state,
+		source:     source,
+		target:     target,
+		title:      title,
+		automerge:  automerge,
+		timeout:    timeout,
+		flags:      flags,
+	}
+}
+
+// configFlag maps flags given on command line to config keys, other flags are skipped.
+func configFlag(flags *pflag.FlagSet) func(flag *pflag.Flag) (string, interface{}) {
+	return func(flag *pflag.Flag) (string, interface{}) {
+		key := flag.Annotations[configKeyAnnotation]
+		if len(key) == 0 {
+			return "", nil
+		}
+		return key[0], posflag.FlagVal(flags, flag)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/adrg/xdg"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const oauthTokensFile = "/oauth_tokens.json"
+const redirectPath = "/callback"
+
+// loginTimeout is time given to the user to grant access in the browser.
+const loginTimeout = time.Minute * 5
+
+var oauthTokensMutex sync.Mutex
+
+// login runs OAuth flow for every selected profile and stores obtained tokens in mergemate state dir.
+func login(out io.Writer, options options) error {
+	profiles, err := readProfiles(options)
+	if err != nil {
+		return configError(err)
+	}
+	for _, profile := range profiles {
+		config := profile.config
+		if config.GitlabUrl == "" {
+			return errors.New("please provide MERGEMATE_GITLAB_URL config entry")
+		}
+		if config.OAuthApplicationId == "" {
+			return errors.New("please provide MERGEMATE_OAUTH_APPLICATION_ID config entry")
+		}
+		if len(profiles) > 1 {
+			fmt.Fprintf(out, "Logging in profile %v.\n", profile.name)
+		}
+		transport, err := newTransport(config)
+		if err != nil {
+			return err
+		}
+		app := gitlab.NewOAuthApp(config.GitlabUrl, config.OAuthApplicationId)
+		app.UseTransport(transport)
+		var token *gitlab.OAuthToken
+		if options.device {
+			token, err = deviceLogin(out, app)
+		} else {
+			token, err = browserLogin(out, app, config.OAuthRedirectPort)
+		}
+		if err != nil {
+			return err
+		}
+
+		session := gitlab.NewOAuthSession(app, *token, func(refreshed gitlab.OAuthToken) error {
+			token = &refreshed
+			return nil
+		})
+		client := configureClient(gitlab.New(config.GitlabUrl, "", config.UserName, ""), transport, session)
+		user, err := client.CurrentUser()
+		if err != nil {
+			return err
+		}
+		if config.UserName != "" && user.Username != config.UserName {
+			return fmt.Errorf("logged in as %v, but MERGEMATE_USER_NAME is %v", user.Username, config.UserName)
+		}
+		// token is looked up with MERGEMATE_USER_NAME, which has to be the user who logged in
+		err = saveOAuthToken(oauthTokenKey(config.GitlabUrl, user.Username), *token)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Logged in to %v as %v.\n", config.GitlabUrl, user.Username)
+		if config.UserName == "" {
+			fmt.Fprintf(out, "Set MERGEMATE_USER_NAME to %v to use the token.\n", user.Username)
+		}
+	}
+	return nil
+}
+
+// browserLogin opens authorization page and receives authorization code on redirect to local listener.
+func browserLogin(out io.Writer, app *gitlab.OAuthApp, port int) (*gitlab.OAuthToken, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%v", port))
+	if err != nil {
+		return nil, fmt.Errorf("can't listen for redirect, change MERGEMATE_OAUTH_REDIRECT_PORT or use --device: %w", err)
+	}
+	redirectUri := fmt.Sprintf("http://127.0.0.1:%v%v", port, redirectPath)
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	verifier, challenge, err := gitlab.NewPkce()
+	if err != nil {
+		return nil, err
+	}
+
+	type redirect struct {
+		code string
+		err  error
+	}
+	redirects := make(chan redirect, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != redirectPath {
+			http.NotFound(writer, request)
+			return
+		}
+		query := request.URL.Query()
+		var result redirect
+		switch {
+		case query.Get("state") != state:
+			result.err = errors.New("redirect doesn't match login request")
+		case query.Get("error") != "":
+			result.err = fmt.Errorf("access wasn't granted: %v %v", query.Get("error"), query.Get("error_description"))
+		default:
+			result.code = query.Get("code")
+		}
+		if result.err != nil {
+			fmt.Fprintf(writer, "mergemate login failed: %v", result.err)
+		} else {
+			fmt.Fprint(writer, "mergemate is logged in, you can close this window.")
+		}
+		select {
+		case redirects <- result:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	authorizationUrl := app.AuthorizationUrl(redirectUri, state, challenge)
+	fmt.Fprintf(out, "Open the following page to grant mergemate access to gitlab:\n%v\n", authorizationUrl)
+	openBrowser(authorizationUrl)
+	select {
+	case result := <-redirects:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return app.ExchangeCode(result.code, redirectUri, verifier)
+	case <-time.After(loginTimeout):
+		return nil, errors.New("access wasn't granted in time")
+	}
+}
+
+// deviceLogin asks the user to enter a code on gitlab page, it works without a browser on the same machine.
+func deviceLogin(out io.Writer, app *gitlab.OAuthApp) (*gitlab.OAuthToken, error) {
+	device, err := app.AuthorizeDevice()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "Open %v and enter code %v to grant mergemate access to gitlab.\n", device.VerificationUri, device.UserCode)
+	return app.WaitForDevice(device)
+}
+
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	// the page can be opened manually when there is no browser
+	_ = cmd.Start()
+}
+
+func randomString() (string, error) {
+	random := make([]byte, 16)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// oauthTokenKey tells apart tokens of gitlab instances and accounts.
+func oauthTokenKey(gitlabUrl string, userName string) string {
+	return strings.TrimSuffix(gitlabUrl, "/") + " " + userName
+}
+
+func oauthTokensPath() string {
+	return filepath.Join(xdg.StateHome, mergeMateDir, oauthTokensFile)
+}
+
+func readOAuthTokens() (map[string]gitlab.OAuthToken, error) {
+	tokens := make(map[string]gitlab.OAuthToken)
+	content, err := os.ReadFile(oauthTokensPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &tokens)
+	return tokens, err
+}
+
+// loadOAuthToken returns token stored by login, nil is returned when the user didn't log in.
+func loadOAuthToken(key string) (*gitlab.OAuthToken, error) {
+	oauthTokensMutex.Lock()
+	defer oauthTokensMutex.Unlock()
+	tokens, err := readOAuthTokens()
+	if err != nil {
+		return nil, err
+	}
+	token, exists := tokens[key]
+	if !exists {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+func saveOAuthToken(key string, token gitlab.OAuthToken) error {
+	oauthTokensMutex.Lock()
+	defer oauthTokensMutex.Unlock()
+	tokens, err := readOAuthTokens()
+	if err != nil {
+		return err
+	}
+	tokens[key] = token
+	content, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	path := oauthTokensPath()
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	temporary := path + ".tmp"
+	err = os.WriteFile(temporary, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporary, path)
+}
@@ -2,17 +2,23 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"github.com/adrg/xdg"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
 	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/aprokopczyk/mergemate/pkg/notify"
+	"github.com/aprokopczyk/mergemate/pkg/offline"
+	"github.com/aprokopczyk/mergemate/pkg/webhook"
 	"github.com/aprokopczyk/mergemate/ui"
 	"github.com/aprokopczyk/mergemate/ui/context"
+	"github.com/aprokopczyk/mergemate/ui/keys"
 	"github.com/aprokopczyk/mergemate/ui/styles"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/knadh/koanf"
-	"github.com/knadh/koanf/parsers/dotenv"
 	"github.com/knadh/koanf/providers/confmap"
 	"github.com/knadh/koanf/providers/env"
-	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/providers/posflag"
+	"github.com/spf13/pflag"
 	"log"
 	"os"
 	"path/filepath"
@@ -20,56 +26,233 @@ import (
 )
 
 type AppConfig struct {
-	GitlabUrl               string `koanf:"MERGEMATE_GITLAB_URL"`
-	ProjectName             string `koanf:"MERGEMATE_PROJECT_NAME"`
-	UserName                string `koanf:"MERGEMATE_USER_NAME"`
-	SlbBranchPrefix         string `koanf:"MERGEMATE_SLB_BRANCH_PREFIX"`
-	TargetBranchPrefixes    string `koanf:"MERGEMATE_TARGET_BRANCH_PREFIXES"`
-	ApiToken                string `koanf:"MERGEMATE_API_TOKEN"`
-	MergeJobIntervalSeconds int    `koanf:"MERGEMATE_MERGE_JOB_INTERVAL_SECONDS"`
-	FavouriteBranches       string `koanf:"MERGEMATE_FAVORITE_BRANCHES"`
+	GitlabUrl                   string `koanf:"MERGEMATE_GITLAB_URL"`
+	ProjectName                 string `koanf:"MERGEMATE_PROJECT_NAME"`
+	UserName                    string `koanf:"MERGEMATE_USER_NAME"`
+	SlbBranchPrefix             string `koanf:"MERGEMATE_SLB_BRANCH_PREFIX"`
+	TargetBranchPrefixes        string `koanf:"MERGEMATE_TARGET_BRANCH_PREFIXES"`
+	ApiToken                    string `koanf:"MERGEMATE_API_TOKEN"`
+	ApiTokenFile                string `koanf:"MERGEMATE_API_TOKEN_FILE"`
+	ApiTokenCommand             string `koanf:"MERGEMATE_API_TOKEN_COMMAND"`
+	OAuthApplicationId          string `koanf:"MERGEMATE_OAUTH_APPLICATION_ID"`
+	OAuthRedirectPort           int    `koanf:"MERGEMATE_OAUTH_REDIRECT_PORT"`
+	CaBundle                    string `koanf:"MERGEMATE_CA_BUNDLE"`
+	ClientCert                  string `koanf:"MERGEMATE_CLIENT_CERT"`
+	ClientKey                   string `koanf:"MERGEMATE_CLIENT_KEY"`
+	InsecureSkipVerify          bool   `koanf:"MERGEMATE_INSECURE_SKIP_VERIFY"`
+	ProxyUrl                    string `koanf:"MERGEMATE_PROXY_URL"`
+	RequestTimeoutSeconds       int    `koanf:"MERGEMATE_REQUEST_TIMEOUT_SECONDS"`
+	MergeJobIntervalSeconds     int    `koanf:"MERGEMATE_MERGE_JOB_INTERVAL_SECONDS"`
+	MergeJobMinIntervalSeconds  int    `koanf:"MERGEMATE_MERGE_JOB_MIN_INTERVAL_SECONDS"`
+	MergeJobMaxIntervalSeconds  int    `koanf:"MERGEMATE_MERGE_JOB_MAX_INTERVAL_SECONDS"`
+	RequestBudgetPerMinute      int    `koanf:"MERGEMATE_REQUEST_BUDGET_PER_MINUTE"`
+	MergeJobParallelism         int    `koanf:"MERGEMATE_MERGE_JOB_PARALLELISM"`
+	RefreshIntervalSeconds      int    `koanf:"MERGEMATE_REFRESH_INTERVAL_SECONDS"`
+	FavouriteBranches           string `koanf:"MERGEMATE_FAVORITE_BRANCHES"`
+	ChatOpsUsers                string `koanf:"MERGEMATE_CHATOPS_USERS"`
+	StatusNotes                 bool   `koanf:"MERGEMATE_STATUS_NOTES"`
+	NotifyWebhookUrl            string `koanf:"MERGEMATE_NOTIFY_WEBHOOK_URL"`
+	NotifyWebhookEvents         string `koanf:"MERGEMATE_NOTIFY_WEBHOOK_EVENTS"`
+	NotifyWebhookTemplate       string `koanf:"MERGEMATE_NOTIFY_WEBHOOK_TEMPLATE"`
+	NotifySmtpAddress           string `koanf:"MERGEMATE_NOTIFY_SMTP_ADDRESS"`
+	NotifySmtpUser              string `koanf:"MERGEMATE_NOTIFY_SMTP_USER"`
+	NotifySmtpPassword          string `koanf:"MERGEMATE_NOTIFY_SMTP_PASSWORD"`
+	NotifySmtpFrom              string `koanf:"MERGEMATE_NOTIFY_SMTP_FROM"`
+	NotifySmtpTo                string `koanf:"MERGEMATE_NOTIFY_SMTP_TO"`
+	NotifySmtpEvents            string `koanf:"MERGEMATE_NOTIFY_SMTP_EVENTS"`
+	NotifySmtpTemplate          string `koanf:"MERGEMATE_NOTIFY_SMTP_TEMPLATE"`
+	NotifyCommand               string `koanf:"MERGEMATE_NOTIFY_COMMAND"`
+	NotifyCommandEvents         string `koanf:"MERGEMATE_NOTIFY_COMMAND_EVENTS"`
+	NotifyCommandTemplate       string `koanf:"MERGEMATE_NOTIFY_COMMAND_TEMPLATE"`
+	WebhookListenAddress        string `koanf:"MERGEMATE_WEBHOOK_LISTEN_ADDRESS"`
+	WebhookSecret               string `koanf:"MERGEMATE_WEBHOOK_SECRET"`
+	WebhookFallbackSeconds      int    `koanf:"MERGEMATE_WEBHOOK_FALLBACK_INTERVAL_SECONDS"`
+	ApiBackend                  string `koanf:"MERGEMATE_API_BACKEND"`
+	ProjectBranchPrefixes       string `koanf:"MERGEMATE_PROJECT_BRANCH_PREFIXES"`
+	ProjectTargetBranchPrefixes string `koanf:"MERGEMATE_PROJECT_TARGET_BRANCH_PREFIXES"`
+	ProjectFavouriteBranches    string `koanf:"MERGEMATE_PROJECT_FAVORITE_BRANCHES"`
+	GroupName                   string `koanf:"MERGEMATE_GROUP_NAME"`
+	// sections available only in structured config file
+	Projects []ProjectConfig     `koanf:"projects"`
+	Policies []PolicyConfig      `koanf:"policies"`
+	Keys     map[string][]string `koanf:"keys"`
+	Theme    ThemeConfig         `koanf:"theme"`
 }
 
-const configFile = "/mergemate/mergemate_config.env"
 const mergeMateDir = "/mergemate"
 const logFile = "/debug.log"
-
-var k = koanf.New(".")
+const queueFile = "/queued_actions.json"
 
 func main() {
+	flags := newFlagSet()
+	err := flags.Parse(os.Args[1:])
+	if errors.Is(err, pflag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
 	loggerFile, err := configureLogFile()
 	if err != nil {
 		log.Fatalf("Error when configuring logfile: %v", err)
 	}
+	defer loggerFile.Close()
 
 	log.Println("Started application")
 
-	config, err := parseConfig()
+	options := parseOptions(flags)
+	args := flags.Args()
+	switch command := strings.Join(args, " "); {
+	case command == "":
+		runUi(options)
+	case command == "config show":
+		err = showConfig(os.Stdout, options)
+	case command == "config init":
+		err = initConfig(os.Stdout, options)
+	case command == "doctor":
+		err = runDoctor(os.Stdout, options)
+	case command == "login":
+		err = login(os.Stdout, options)
+	case args[0] == "mr" || args[0] == "branch" || args[0] == "watch":
+		err = runCommand(os.Stdout, options, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%v'\n", command)
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+}
+
+func runUi(options options) {
+	profiles, err := loadProfiles(options)
 	if err != nil {
 		log.Fatalf("Error loading config: %v", err)
 	}
-	err = validateConfig(config)
+	err = checkWebhookProfiles(profiles)
+	if err != nil {
+		log.Fatalf("Invalid webhook config: %v.", err)
+	}
+	// settings of the whole application, i.e. refresh interval or webhook server, are taken from the first profile
+	config := profiles[0].config
+	err = keys.Rebind(config.Keys)
+	if err != nil {
+		log.Fatalf("Invalid keys config: %v.", err)
+	}
+	offlineStore, err := offline.New(filepath.Join(xdg.CacheHome, mergeMateDir))
+	if err != nil {
+		log.Fatalf("Error when creating offline cache: %v", err)
+	}
+	actionQueue, err := offline.NewQueue(filepath.Join(xdg.StateHome, mergeMateDir, queueFile))
 	if err != nil {
-		log.Fatalf("Invalid config: %v.", err)
+		log.Fatalf("Error when loading queued actions: %v", err)
 	}
-	client := gitlab.New(config.GitlabUrl, config.ProjectName, config.UserName, config.ApiToken)
 	var appContext = context.AppContext{
-		Styles:               styles.NewStyles(),
-		GitlabClient:         client,
-		MergeJobInterval:     config.MergeJobIntervalSeconds,
-		UserBranchPrefix:     config.SlbBranchPrefix,
-		TargetBranchPrefixes: strings.Split(config.TargetBranchPrefixes, ","),
-		FavouriteBranches:    strings.Split(config.FavouriteBranches, ","),
-		TablePageSize:        styles.MinTablePageSize,
+		Styles:        styles.NewStyles(theme(config.Theme)),
+		TablePageSize: styles.MinTablePageSize,
+		OfflineStore:  offlineStore,
+		ActionQueue:   actionQueue,
+	}
+	appContext.SetRefreshInterval(config.RefreshIntervalSeconds)
+	appContext.SetMergeJobInterval(config.MergeJobIntervalSeconds, config.WebhookFallbackSeconds)
+	var notifiers []*notify.Dispatcher
+	var factories []*projectFactory
+	for _, profile := range profiles {
+		notifier, err := notify.New(notifyConfig(profile.config))
+		if err != nil {
+			log.Fatalf("Invalid notifications config of profile %v: %v.", profile.name, err)
+		}
+		notifiers = append(notifiers, notifier)
+		factory, err := newProjectFactory(profile.config, profile.session, []engine.Listener{notifier}, profile.config.MergeJobIntervalSeconds, profile.prefix)
+		if err != nil {
+			log.Fatalf("Invalid config of profile %v: %v.", profile.name, err)
+		}
+		factories = append(factories, factory)
+		if factory.transport.Insecure() {
+			log.Printf("WARNING: TLS certificate verification is disabled for %v, connection to gitlab can be intercepted", profile.config.GitlabUrl)
+			appContext.InsecureConnection = true
+		}
+		for path, err := range factory.readRepositoryConfigs(projectNames(profile.config)) {
+			log.Printf("Error when reading repository config of project %v, only your config is used: %v", path, err)
+		}
+		for _, name := range projectNames(profile.config) {
+			appContext.AddProject(factory.newProject(name))
+		}
+		for _, name := range groupNames(profile.config) {
+			appContext.Groups = append(appContext.Groups, factory.newGroup(name))
+		}
+	}
+	if config.WebhookListenAddress != "" {
+		appContext.WebhookServer = webhook.New(webhook.Config{
+			ListenAddress: config.WebhookListenAddress,
+			Secret:        config.WebhookSecret,
+			Projects:      webhookProjects(config),
+			NamePrefix:    profiles[0].prefix,
+		})
+		err = appContext.WebhookServer.Start()
+		if err != nil {
+			log.Fatalf("Error when starting webhook server: %v", err)
+		}
+		defer appContext.WebhookServer.Close()
 	}
 	p := tea.NewProgram(ui.New(&appContext), tea.WithAltScreen())
+	watcher, err := watchConfig(options, profiles, factories, p.Send)
+	if err != nil {
+		log.Printf("Config file won't be reloaded on changes: %v", err)
+	} else if watcher != nil {
+		defer watcher.Close()
+	}
 
 	if _, err := p.Run(); err != nil {
 		log.Fatal(err)
 	}
-	err = loggerFile.Close()
-	if err != nil {
-		log.Fatalf("Error closing logfile: %v", err)
+	// merge jobs can still be running, they notify listeners until they are stopped
+	for _, factory := range factories {
+		factory.stop()
+	}
+	for _, notifier := range notifiers {
+		notifier.Close()
+	}
+}
+
+// checkWebhookProfiles fails when webhook server is used with several profiles, its events carry projects of a single
+// profile.
+func checkWebhookProfiles(profiles []profile) error {
+	if len(profiles) < 2 {
+		return nil
+	}
+	for _, profile := range profiles {
+		if profile.config.WebhookListenAddress != "" {
+			return fmt.Errorf("MERGEMATE_WEBHOOK_LISTEN_ADDRESS of profile %v can't be used when several profiles are shown side by side", profile.name)
+		}
+	}
+	return nil
+}
+
+func notifyConfig(config *AppConfig) notify.Config {
+	return notify.Config{
+		Webhook: notify.WebhookConfig{
+			Url:      config.NotifyWebhookUrl,
+			Events:   strings.Split(config.NotifyWebhookEvents, ","),
+			Template: config.NotifyWebhookTemplate,
+		},
+		Smtp: notify.SmtpConfig{
+			Address:  config.NotifySmtpAddress,
+			User:     config.NotifySmtpUser,
+			Password: config.NotifySmtpPassword,
+			From:     config.NotifySmtpFrom,
+			To:       strings.Split(config.NotifySmtpTo, ","),
+			Events:   strings.Split(config.NotifySmtpEvents, ","),
+			Template: config.NotifySmtpTemplate,
+		},
+		Command: notify.CommandConfig{
+			Command:  config.NotifyCommand,
+			Events:   strings.Split(config.NotifyCommandEvents, ","),
+			Template: config.NotifyCommandTemplate,
+		},
 	}
 }
 
@@ -80,15 +263,21 @@ func configureLogFile() (*os.File, error) {
 		return nil, err
 	}
 	logFilePath := filepath.Join(logDir, logFile)
-	return tea.LogToFile(logFilePath, "debug")
+	file, err := tea.LogToFile(logFilePath, "debug")
+	if err != nil {
+		return nil, err
+	}
+	logRedactor.setOutput(file)
+	log.SetOutput(logRedactor)
+	return file, nil
 }
 
 func validateConfig(config *AppConfig) error {
 	if len(config.GitlabUrl) == 0 {
 		return errors.New("please provide MERGEMATE_GITLAB_URL config entry")
 	}
-	if len(config.ProjectName) == 0 {
-		return errors.New("please provide MERGEMATE_PROJECT_NAME config entry")
+	if len(projectNames(config)) == 0 && len(groupNames(config)) == 0 {
+		return errors.New("please provide MERGEMATE_PROJECT_NAME or MERGEMATE_GROUP_NAME config entry")
 	}
 	if len(config.UserName) == 0 {
 		return errors.New("please provide MERGEMATE_USER_NAME config entry")
@@ -97,44 +286,125 @@ func validateConfig(config *AppConfig) error {
 		return errors.New("please provide MERGEMATE_SLB_BRANCH_PREFIX config entry")
 	}
 	if len(config.ApiToken) == 0 {
-		return errors.New("please provide MERGEMATE_API_TOKEN config entry")
+		return errors.New("please provide MERGEMATE_API_TOKEN, MERGEMATE_API_TOKEN_FILE or MERGEMATE_API_TOKEN_COMMAND config entry or log in with mergemate login")
 	}
 	if config.MergeJobIntervalSeconds <= 0 {
 		return errors.New("MERGEMATE_MERGE_JOB_INTERVAL_SECONDS has to be bigger than 0")
 	}
-	return nil
+	if len(config.WebhookListenAddress) > 0 && len(config.WebhookSecret) == 0 {
+		return errors.New("please provide MERGEMATE_WEBHOOK_SECRET config entry when MERGEMATE_WEBHOOK_LISTEN_ADDRESS is set")
+	}
+	if config.MergeJobMinIntervalSeconds <= 0 || config.MergeJobMinIntervalSeconds > config.MergeJobIntervalSeconds {
+		return errors.New("MERGEMATE_MERGE_JOB_MIN_INTERVAL_SECONDS has to be bigger than 0 and not bigger than MERGEMATE_MERGE_JOB_INTERVAL_SECONDS")
+	}
+	if config.MergeJobMaxIntervalSeconds < config.MergeJobIntervalSeconds {
+		return errors.New("MERGEMATE_MERGE_JOB_MAX_INTERVAL_SECONDS can't be smaller than MERGEMATE_MERGE_JOB_INTERVAL_SECONDS")
+	}
+	if config.RequestTimeoutSeconds <= 0 {
+		return errors.New("MERGEMATE_REQUEST_TIMEOUT_SECONDS has to be bigger than 0")
+	}
+	if config.RefreshIntervalSeconds <= 0 {
+		return errors.New("MERGEMATE_REFRESH_INTERVAL_SECONDS has to be bigger than 0")
+	}
+	if config.MergeJobParallelism <= 0 {
+		return errors.New("MERGEMATE_MERGE_JOB_PARALLELISM has to be bigger than 0")
+	}
+	if config.RequestBudgetPerMinute < 0 {
+		return errors.New("MERGEMATE_REQUEST_BUDGET_PER_MINUTE can't be negative")
+	}
+	if config.WebhookFallbackSeconds <= 0 {
+		return errors.New("MERGEMATE_WEBHOOK_FALLBACK_INTERVAL_SECONDS has to be bigger than 0")
+	}
+	if config.ApiBackend != gitlab.BackendRest && config.ApiBackend != gitlab.BackendGraphql {
+		return errors.New("MERGEMATE_API_BACKEND has to be either rest or graphql")
+	}
+	err := validatePolicies(config)
+	if err != nil {
+		return err
+	}
+	return validateFavourites(config)
+}
+
+var defaultValues = map[string]interface{}{
+	"MERGEMATE_MERGE_JOB_INTERVAL_SECONDS":        60,
+	"MERGEMATE_STATUS_NOTES":                      false,
+	"MERGEMATE_WEBHOOK_FALLBACK_INTERVAL_SECONDS": 300,
+	"MERGEMATE_MERGE_JOB_MIN_INTERVAL_SECONDS":    10,
+	"MERGEMATE_MERGE_JOB_MAX_INTERVAL_SECONDS":    900,
+	"MERGEMATE_REQUEST_BUDGET_PER_MINUTE":         300,
+	"MERGEMATE_REFRESH_INTERVAL_SECONDS":          60,
+	"MERGEMATE_MERGE_JOB_PARALLELISM":             4,
+	"MERGEMATE_API_BACKEND":                       gitlab.BackendRest,
+	"MERGEMATE_OAUTH_REDIRECT_PORT":               7171,
+	"MERGEMATE_REQUEST_TIMEOUT_SECONDS":           10,
+	"MERGEMATE_INSECURE_SKIP_VERIFY":              false,
 }
 
-func parseConfig() (*AppConfig, error) {
-	configFilePath := filepath.Join(xdg.ConfigHome, configFile)
+// configLayer is a source of config values, values of later layers override values of earlier ones.
+type configLayer struct {
+	source string
+	values *koanf.Koanf
+}
 
-	// init default values
-	err := k.Load(confmap.Provider(map[string]interface{}{
-		"MERGEMATE_MERGE_JOB_INTERVAL_SECONDS": 60,
-	}, ""), nil)
+// configLayers returns default values, config file, profile section of config file, environment variables and flags.
+func configLayers(fileConfig configFile, profile string, flags *pflag.FlagSet) ([]configLayer, error) {
+	defaults := koanf.New(".")
+	err := defaults.Load(confmap.Provider(defaultValues, ""), nil)
 	if err != nil {
 		return nil, err
 	}
+	layers := []configLayer{{source: "default", values: defaults}}
+	if fileConfig.path != "" {
+		layers = append(layers, configLayer{source: fileConfig.path, values: fileConfig.values})
+		if profile != "" {
+			layers = append(layers, configLayer{source: fmt.Sprintf("%v (profile %v)", fileConfig.path, profile), values: fileConfig.values.Cut(profile)})
+		}
+	}
 
-	_, err = os.Stat(configFilePath)
+	environment := koanf.New(".")
+	err = environment.Load(env.Provider(envPrefix, ".", func(s string) string { return s }), nil)
 	if err != nil {
 		return nil, err
 	}
+	layers = append(layers, configLayer{source: "environment", values: environment})
 
-	// file exists, we can load config
-	err = k.Load(file.Provider(configFilePath), dotenv.Parser())
+	flagValues := koanf.New(".")
+	err = flagValues.Load(posflag.ProviderWithFlag(flags, ".", nil, configFlag(flags)), nil)
 	if err != nil {
 		return nil, err
 	}
+	return append(layers, configLayer{source: "flag", values: flagValues}), nil
+}
 
-	err = k.Load(env.Provider("", ".", func(s string) string { return s }), nil)
-	if err != nil {
-		return nil, err
+func parseConfig(layers []configLayer) (*AppConfig, error) {
+	k := koanf.New(".")
+	for _, layer := range layers {
+		err := k.Merge(layer.values)
+		if err != nil {
+			return nil, err
+		}
 	}
 	var out AppConfig
-	err = k.Unmarshal("", &out)
+	err := k.Unmarshal("", &out)
 	if err != nil {
 		return nil, err
 	}
+	// default bounds follow merge job interval, configs written before the bounds existed can use any interval
+	if !setByUser(layers, "MERGEMATE_MERGE_JOB_MIN_INTERVAL_SECONDS") && out.MergeJobMinIntervalSeconds > out.MergeJobIntervalSeconds {
+		out.MergeJobMinIntervalSeconds = out.MergeJobIntervalSeconds
+	}
+	if !setByUser(layers, "MERGEMATE_MERGE_JOB_MAX_INTERVAL_SECONDS") && out.MergeJobMaxIntervalSeconds < out.MergeJobIntervalSeconds {
+		out.MergeJobMaxIntervalSeconds = out.MergeJobIntervalSeconds
+	}
 	return &out, nil
 }
+
+// setByUser tells whether key is set by any layer other than default values.
+func setByUser(layers []configLayer, key string) bool {
+	for _, layer := range layers[1:] {
+		if layer.values.Exists(key) {
+			return true
+		}
+	}
+	return false
+}
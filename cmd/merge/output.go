@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// output formats of commands used in scripts
+const (
+	outputTable = "table"
+	outputJson  = "json"
+	outputCsv   = "csv"
+)
+
+// records are printed as aligned columns, CSV with a header or a JSON array of records.
+type records struct {
+	columns []string
+	rows    [][]string
+	// values are encoded as JSON, there is one value per row
+	values interface{}
+}
+
+func writeRecords(out io.Writer, format string, records records) error {
+	switch format {
+	case outputJson:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records.values)
+	case outputCsv:
+		writer := csv.NewWriter(out)
+		err := writer.Write(records.columns)
+		if err != nil {
+			return err
+		}
+		err = writer.WriteAll(records.rows)
+		if err != nil {
+			return err
+		}
+		return writer.Error()
+	default:
+		writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, strings.ToUpper(strings.Join(records.columns, "\t")))
+		// tabs and line breaks of values would break columns
+		replacer := strings.NewReplacer("\t", " ", "\n", " ")
+		for _, row := range records.rows {
+			cells := make([]string, 0, len(row))
+			for _, cell := range row {
+				cells = append(cells, replacer.Replace(cell))
+			}
+			fmt.Fprintln(writer, strings.Join(cells, "\t"))
+		}
+		return writer.Flush()
+	}
+}
+
+func checkOutput(format string) error {
+	switch format {
+	case outputTable, outputJson, outputCsv:
+		return nil
+	}
+	return usageError("unknown output format %v, use one of: %v, %v, %v", format, outputTable, outputJson, outputCsv)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/knadh/koanf"
+	"sort"
+	"strings"
+)
+
+// defaultProfile names config written outside of profile sections.
+const defaultProfile = "default"
+
+type profile struct {
+	name string
+	// prefix tells apart projects of profiles shown side by side, it's empty when a single profile is used
+	prefix string
+	config *AppConfig
+	layers []configLayer
+	// session is set when the token was obtained with login
+	session *gitlab.OAuthSession
+	// read is config before the token was resolved, reloaded config is compared with it
+	read *AppConfig
+}
+
+// loadProfiles parses and validates config of every profile, tokens given with a file or a command are resolved.
+func loadProfiles(options options) ([]profile, error) {
+	profiles, err := readProfiles(options)
+	if err != nil {
+		return nil, err
+	}
+	for i, profile := range profiles {
+		read := *profile.config
+		profiles[i].read = &read
+		profiles[i].session, err = resolveToken(profile.config)
+		if err == nil {
+			err = validateConfig(profile.config)
+		}
+		if err != nil {
+			if len(profiles) == 1 && profile.name == defaultProfile {
+				return nil, err
+			}
+			return nil, fmt.Errorf("profile %v: %w", profile.name, err)
+		}
+		redactSecrets(profile.config)
+	}
+	return profiles, nil
+}
+
+// readProfiles parses config of every profile without validating it, default profile is used when no profile is given.
+func readProfiles(options options) ([]profile, error) {
+	fileConfig, err := loadConfigFile(options.configPath)
+	if err != nil {
+		return nil, err
+	}
+	err = checkConfigFile(fileConfig)
+	if err != nil {
+		return nil, err
+	}
+	names := options.profiles
+	if len(names) == 0 {
+		names = []string{defaultProfile}
+	}
+	defined := definedProfiles(fileConfig.values)
+	var profiles []profile
+	for _, name := range names {
+		if !contains(defined, name) {
+			return nil, fmt.Errorf("profile %v is not defined in config file, defined profiles: %v", name, strings.Join(defined, ", "))
+		}
+		if containsProfile(profiles, name) {
+			return nil, fmt.Errorf("profile %v is given twice", name)
+		}
+		section := name
+		if name == defaultProfile {
+			section = ""
+		}
+		layers, err := configLayers(fileConfig, section, options.flags)
+		if err != nil {
+			return nil, err
+		}
+		config, err := parseConfig(layers)
+		if err != nil {
+			return nil, err
+		}
+		prefix := ""
+		if len(names) > 1 {
+			prefix = name
+		}
+		profiles = append(profiles, profile{name: name, prefix: prefix, config: config, layers: layers})
+	}
+	if len(profiles) == 0 {
+		return nil, errors.New("no profile to show")
+	}
+	return profiles, nil
+}
+
+// definedProfiles lists sections of config file, entries written as profile.MERGEMATE_KEY=value form a section.
+func definedProfiles(fileConfig *koanf.Koanf) []string {
+	profiles := []string{defaultProfile}
+	for key, value := range fileConfig.Raw() {
+		if _, section := value.(map[string]interface{}); section && key != defaultProfile && !structuredSections[key] {
+			profiles = append(profiles, key)
+		}
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+func containsProfile(profiles []profile, name string) bool {
+	for _, profile := range profiles {
+		if profile.name == name {
+			return true
+		}
+	}
+	return false
+}
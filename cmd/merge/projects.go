@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/aprokopczyk/mergemate/ui/context"
+	"github.com/aprokopczyk/mergemate/ui/keys"
+	"strings"
+	"sync"
+	"time"
+)
+
+func projectNames(config *AppConfig) []string {
+	names := splitList(config.ProjectName)
+	for _, project := range config.Projects {
+		if !contains(names, project.Name) {
+			names = append(names, project.Name)
+		}
+	}
+	return names
+}
+
+func groupNames(config *AppConfig) []string {
+	return splitList(config.GroupName)
+}
+
+func splitList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// projectSettings parses per project overrides written as project=value;other/project=value, projects are checked
+// only when no group is configured as projects of groups aren't known upfront.
+func projectSettings(value string, projects []string, checkProjects bool) (map[string]string, error) {
+	settings := make(map[string]string)
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		project, setting, found := strings.Cut(entry, "=")
+		project = strings.TrimSpace(project)
+		if !found || project == "" {
+			return nil, fmt.Errorf("'%v' should be written as project=value", entry)
+		}
+		if checkProjects && !contains(projects, project) {
+			return nil, fmt.Errorf("project %v is not listed in MERGEMATE_PROJECT_NAME", project)
+		}
+		settings[project] = strings.TrimSpace(setting)
+	}
+	return settings, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type projectFactory struct {
+	session   *gitlab.OAuthSession
+	transport *gitlab.Transport
+	prefix    string
+	listeners []engine.Listener
+	// requests of all clients are counted together, so merge jobs of all projects share the request budget
+	requests *gitlab.SharedRequests
+	// settings below are replaced when config is reloaded, projects of groups can be created at any time
+	mutex            sync.Mutex
+	config           *AppConfig
+	mergeJobInterval int
+	overrides        projectOverrides
+	// paths of created projects, keyed by project name
+	paths map[string]string
+	// repositories hold repository config of projects, keyed by project path
+	repositories map[string]RepositoryConfig
+	// engines of created projects, they are stopped before listeners are closed
+	engines []*engine.Engine
+	stopped bool
+}
+
+// settingSources name config entries which settings of a project come from, entry is empty when setting isn't set.
+type settingSources struct {
+	branchPrefix         string
+	targetBranchPrefixes string
+	favouriteBranches    string
+}
+
+// projectOverrides hold per project settings, keyed by project path.
+type projectOverrides struct {
+	branchPrefixes       map[string]string
+	targetBranchPrefixes map[string]string
+	favouriteBranches    map[string]string
+}
+
+// newProjectFactory validates per project settings, request budget is shared by all projects, including the ones
+// found in groups.
+func newProjectFactory(config *AppConfig, session *gitlab.OAuthSession, listeners []engine.Listener, mergeJobInterval int, prefix string) (*projectFactory, error) {
+	overrides, err := parseOverrides(config)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newTransport(config)
+	if err != nil {
+		return nil, err
+	}
+	return &projectFactory{
+		config:           config,
+		session:          session,
+		transport:        transport,
+		prefix:           prefix,
+		listeners:        listeners,
+		mergeJobInterval: mergeJobInterval,
+		requests:         gitlab.NewSharedRequests(),
+		overrides:        overrides,
+		paths:            make(map[string]string),
+		repositories:     make(map[string]RepositoryConfig),
+	}, nil
+}
+
+func parseOverrides(config *AppConfig) (projectOverrides, error) {
+	names := projectNames(config)
+	checkProjects := len(groupNames(config)) == 0
+	branchPrefixes, err := projectSettings(config.ProjectBranchPrefixes, names, checkProjects)
+	if err != nil {
+		return projectOverrides{}, fmt.Errorf("MERGEMATE_PROJECT_BRANCH_PREFIXES: %w", err)
+	}
+	targetBranchPrefixes, err := projectSettings(config.ProjectTargetBranchPrefixes, names, checkProjects)
+	if err != nil {
+		return projectOverrides{}, fmt.Errorf("MERGEMATE_PROJECT_TARGET_BRANCH_PREFIXES: %w", err)
+	}
+	favouriteBranches, err := projectSettings(config.ProjectFavouriteBranches, names, checkProjects)
+	if err != nil {
+		return projectOverrides{}, fmt.Errorf("MERGEMATE_PROJECT_FAVORITE_BRANCHES: %w", err)
+	}
+	for path, branches := range favouriteBranches {
+		err = keys.CheckFavourites(favourites(strings.Split(branches, ",")), config.Keys)
+		if err != nil {
+			return projectOverrides{}, fmt.Errorf("MERGEMATE_PROJECT_FAVORITE_BRANCHES, project %v: %w", path, err)
+		}
+	}
+	return projectOverrides{
+		branchPrefixes:       branchPrefixes,
+		targetBranchPrefixes: targetBranchPrefixes,
+		favouriteBranches:    favouriteBranches,
+	}, nil
+}
+
+// newProject creates gitlab client and merge engine of a project.
+func (factory *projectFactory) newProject(path string) *context.Project {
+	factory.mutex.Lock()
+	defer factory.mutex.Unlock()
+	config := factory.config
+	client := factory.newClient(path)
+	// backend is validated together with the rest of config
+	_ = client.SetBackend(config.ApiBackend)
+	minInterval, interval, maxInterval := factory.intervals()
+	project := &context.Project{
+		Name:         context.ProjectName(factory.prefix, path),
+		GitlabClient: client,
+		MergeEngine: engine.New(client, engine.Config{
+			UserName:      config.UserName,
+			ChatOpsUsers:  strings.Split(config.ChatOpsUsers, ","),
+			StatusNotes:   config.StatusNotes,
+			Listeners:     factory.listeners,
+			Interval:      interval,
+			MinInterval:   minInterval,
+			MaxInterval:   maxInterval,
+			RequestBudget: config.RequestBudgetPerMinute,
+			Parallelism:   config.MergeJobParallelism,
+			Policies:      policies(factory.projectConfig(path), config, factory.repositories[path]),
+		}),
+	}
+	factory.applySettings(project, path)
+	factory.paths[project.Name] = path
+	factory.engines = append(factory.engines, project.MergeEngine)
+	if factory.stopped {
+		project.MergeEngine.Stop()
+	}
+	return project
+}
+
+// stop stops merge engines of created projects, projects created later are stopped right away.
+func (factory *projectFactory) stop() {
+	factory.mutex.Lock()
+	factory.stopped = true
+	engines := factory.engines
+	factory.mutex.Unlock()
+	for _, mergeEngine := range engines {
+		mergeEngine.Stop()
+	}
+}
+
+func (factory *projectFactory) newClient(path string) *gitlab.ApiClient {
+	config := factory.config
+	client := configureClient(gitlab.New(config.GitlabUrl, path, config.UserName, config.ApiToken), factory.transport, factory.session)
+	client.ShareRequests(factory.requests)
+	return client
+}
+
+// readRepositoryConfigs fetches repository config of projects in parallel, it has to be done before projects are
+// created. Errors are returned by project path, projects with errors are created without repository config.
+func (factory *projectFactory) readRepositoryConfigs(paths []string) map[string]error {
+	errs := make(map[string]error)
+	var wait sync.WaitGroup
+	for _, path := range paths {
+		wait.Add(1)
+		go func(path string) {
+			defer wait.Done()
+			factory.mutex.Lock()
+			client := factory.newClient(path)
+			factory.mutex.Unlock()
+			config, err := readRepositoryConfig(client)
+			factory.mutex.Lock()
+			defer factory.mutex.Unlock()
+			if err == nil {
+				err = keys.CheckFavourites(favouritesOf(config.FavoriteBranches), factory.config.Keys)
+				if err != nil {
+					err = fmt.Errorf("%v: %w", repositorySource(config), err)
+				}
+			}
+			if err != nil {
+				errs[path] = err
+				return
+			}
+			factory.repositories[path] = config
+		}(path)
+	}
+	wait.Wait()
+	return errs
+}
+
+// applySettings sets branch prefixes and favourite branches of a project, the most specific setting wins and
+// repository config is used only for settings which aren't set at all.
+func (factory *projectFactory) applySettings(project *context.Project, path string) settingSources {
+	config := factory.config
+	sources := settingSources{branchPrefix: "MERGEMATE_SLB_BRANCH_PREFIX"}
+	project.UserBranchPrefix = config.SlbBranchPrefix
+	project.TargetBranchPrefixes = strings.Split(config.TargetBranchPrefixes, ",")
+	if config.TargetBranchPrefixes != "" {
+		sources.targetBranchPrefixes = "MERGEMATE_TARGET_BRANCH_PREFIXES"
+	}
+	project.FavouriteBranches = favourites(strings.Split(config.FavouriteBranches, ","))
+	if config.FavouriteBranches != "" {
+		sources.favouriteBranches = "MERGEMATE_FAVORITE_BRANCHES"
+	}
+	if prefix, exists := factory.overrides.branchPrefixes[path]; exists {
+		project.UserBranchPrefix = prefix
+		sources.branchPrefix = "MERGEMATE_PROJECT_BRANCH_PREFIXES"
+	}
+	if prefixes, exists := factory.overrides.targetBranchPrefixes[path]; exists {
+		project.TargetBranchPrefixes = strings.Split(prefixes, ",")
+		sources.targetBranchPrefixes = "MERGEMATE_PROJECT_TARGET_BRANCH_PREFIXES"
+	}
+	if favouriteBranches, exists := factory.overrides.favouriteBranches[path]; exists {
+		project.FavouriteBranches = favourites(strings.Split(favouriteBranches, ","))
+		sources.favouriteBranches = "MERGEMATE_PROJECT_FAVORITE_BRANCHES"
+	}
+	// section of structured config file is the most specific
+	projectConfig := factory.projectConfig(path)
+	if projectConfig.BranchPrefix != "" {
+		project.UserBranchPrefix = projectConfig.BranchPrefix
+		sources.branchPrefix = "projects"
+	}
+	if len(projectConfig.TargetBranchPrefixes) > 0 {
+		project.TargetBranchPrefixes = projectConfig.TargetBranchPrefixes
+		sources.targetBranchPrefixes = "projects"
+	}
+	if len(projectConfig.FavoriteBranches) > 0 {
+		project.FavouriteBranches = favouritesOf(projectConfig.FavoriteBranches)
+		sources.favouriteBranches = "projects"
+	}
+	repository := factory.repositories[path]
+	if sources.targetBranchPrefixes == "" && len(repository.TargetBranchPrefixes) > 0 {
+		project.TargetBranchPrefixes = repository.TargetBranchPrefixes
+		sources.targetBranchPrefixes = repositoryConfigFile
+	}
+	if sources.favouriteBranches == "" && len(repository.FavoriteBranches) > 0 {
+		project.FavouriteBranches = favouritesOf(repository.FavoriteBranches)
+		sources.favouriteBranches = repositoryConfigFile
+	}
+	return sources
+}
+
+// effectiveSettings returns settings a project would be created with.
+func (factory *projectFactory) effectiveSettings(path string) (context.Project, settingSources) {
+	factory.mutex.Lock()
+	defer factory.mutex.Unlock()
+	var project context.Project
+	sources := factory.applySettings(&project, path)
+	return project, sources
+}
+
+func (factory *projectFactory) intervals() (time.Duration, time.Duration, time.Duration) {
+	config := factory.config
+	return time.Second * time.Duration(config.MergeJobMinIntervalSeconds),
+		time.Second * time.Duration(factory.mergeJobInterval),
+		time.Second * time.Duration(config.MergeJobMaxIntervalSeconds)
+}
+
+// reconfigure applies reloaded settings to projects created so far, projects are replaced as background jobs can use them.
+func (factory *projectFactory) reconfigure(appContext *context.AppContext, config *AppConfig, overrides projectOverrides, mergeJobInterval int) {
+	factory.mutex.Lock()
+	factory.config = config
+	factory.overrides = overrides
+	factory.mergeJobInterval = mergeJobInterval
+	paths := make(map[string]string)
+	for name, path := range factory.paths {
+		paths[name] = path
+	}
+	factory.mutex.Unlock()
+	// projects of groups are created while context is locked, so factory can't be locked when context is used
+	var projects []*context.Project
+	for name := range paths {
+		if project := appContext.Project(name); project != nil {
+			projects = append(projects, project)
+		}
+	}
+	factory.mutex.Lock()
+	var updated []*context.Project
+	for _, project := range projects {
+		changed := *project
+		factory.applySettings(&changed, paths[project.Name])
+		updated = append(updated, &changed)
+	}
+	minInterval, interval, maxInterval := factory.intervals()
+	factory.mutex.Unlock()
+	for _, project := range updated {
+		project.MergeEngine.SetIntervals(interval, minInterval, maxInterval)
+		appContext.ReplaceProject(project)
+	}
+}
+
+func (factory *projectFactory) projectConfig(path string) ProjectConfig {
+	for _, project := range factory.config.Projects {
+		if project.Name == path {
+			return project
+		}
+	}
+	return ProjectConfig{}
+}
+
+func favouritesOf(branches []FavoriteBranchConfig) []keys.Favourite {
+	var result []keys.Favourite
+	for _, favourite := range branches {
+		result = append(result, keys.Favourite{Branch: favourite.Branch, Key: favourite.Key})
+	}
+	return result
+}
+
+func favourites(branches []string) []keys.Favourite {
+	var result []keys.Favourite
+	for _, branch := range branches {
+		result = append(result, keys.Favourite{Branch: branch})
+	}
+	return result
+}
+
+func (factory *projectFactory) newGroup(name string) *context.Group {
+	config := factory.config
+	client := configureClient(gitlab.NewGroup(config.GitlabUrl, name, config.UserName, config.ApiToken), factory.transport, factory.session)
+	client.ShareRequests(factory.requests)
+	return &context.Group{
+		Name:         name,
+		GitlabClient: client,
+		Prefix:       factory.prefix,
+		NewProject:   factory.newProject,
+	}
+}
+
+func newTransport(config *AppConfig) (*gitlab.Transport, error) {
+	return gitlab.NewTransport(gitlab.TransportConfig{
+		CaBundle:           config.CaBundle,
+		ClientCert:         config.ClientCert,
+		ClientKey:          config.ClientKey,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		ProxyUrl:           config.ProxyUrl,
+		Timeout:            time.Second * time.Duration(config.RequestTimeoutSeconds),
+	})
+}
+
+// configureClient applies TLS, proxy and timeout settings and switches client to OAuth token when the user logged in.
+func configureClient(client *gitlab.ApiClient, transport *gitlab.Transport, session *gitlab.OAuthSession) *gitlab.ApiClient {
+	client.UseTransport(transport)
+	if session != nil {
+		client.UseOAuth(session)
+	}
+	return client
+}
+
+// webhookProjects lists projects accepted by webhook server, hooks of all projects are accepted when groups are used.
+func webhookProjects(config *AppConfig) []string {
+	if len(groupNames(config)) > 0 {
+		return nil
+	}
+	return projectNames(config)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aprokopczyk/mergemate/ui/context"
+	"github.com/aprokopczyk/mergemate/ui/keys"
+	"github.com/aprokopczyk/mergemate/ui/styles"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/fsnotify/fsnotify"
+	"log"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// liveEntries are applied while running when config file changes, changes of other entries need a restart.
+// Section projects is applied as well, as long as it lists the same projects with the same policies.
+var liveEntries = map[string]bool{
+	"MERGEMATE_SLB_BRANCH_PREFIX":                 true,
+	"MERGEMATE_TARGET_BRANCH_PREFIXES":            true,
+	"MERGEMATE_FAVORITE_BRANCHES":                 true,
+	"MERGEMATE_PROJECT_BRANCH_PREFIXES":           true,
+	"MERGEMATE_PROJECT_TARGET_BRANCH_PREFIXES":    true,
+	"MERGEMATE_PROJECT_FAVORITE_BRANCHES":         true,
+	"MERGEMATE_MERGE_JOB_INTERVAL_SECONDS":        true,
+	"MERGEMATE_MERGE_JOB_MIN_INTERVAL_SECONDS":    true,
+	"MERGEMATE_MERGE_JOB_MAX_INTERVAL_SECONDS":    true,
+	"MERGEMATE_WEBHOOK_FALLBACK_INTERVAL_SECONDS": true,
+	"MERGEMATE_REFRESH_INTERVAL_SECONDS":          true,
+	"keys":                                        true,
+	"theme":                                       true,
+}
+
+// reloadDelay lets editors finish writing config file before it's read again.
+const reloadDelay = 300 * time.Millisecond
+
+type configWatcher struct {
+	options   options
+	profiles  []profile
+	factories []*projectFactory
+	send      func(tea.Msg)
+	watcher   *fsnotify.Watcher
+}
+
+// watchConfig reloads config file whenever it changes and sends the result with send, there is nothing to watch
+// when config file isn't used.
+func watchConfig(options options, profiles []profile, factories []*projectFactory, send func(tea.Msg)) (*configWatcher, error) {
+	path := options.configPath
+	if path == "" {
+		var err error
+		path, err = findConfigFile()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if path == "" {
+		return nil, nil
+	}
+	watched := []string{filepath.Clean(path)}
+	if target, err := filepath.EvalSymlinks(path); err == nil && filepath.Clean(target) != watched[0] {
+		watched = append(watched, filepath.Clean(target))
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// directories are watched as editors often replace config file instead of writing to it
+	for _, file := range watched {
+		err = watcher.Add(filepath.Dir(file))
+		if err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	w := &configWatcher{options: options, profiles: profiles, factories: factories, send: send, watcher: watcher}
+	go w.watch(watched)
+	log.Printf("Watching config file %v for changes", path)
+	return w, nil
+}
+
+func (w *configWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+func (w *configWatcher) watch(files []string) {
+	var changed <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op != fsnotify.Chmod && contains(files, filepath.Clean(event.Name)) {
+				// every event postpones reload, file is read once it's written completely
+				changed = time.After(reloadDelay)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Error when watching config file %v", err)
+		case <-changed:
+			changed = nil
+			w.send(w.reload())
+		}
+	}
+}
+
+// reload compares config file with the running config, entries which can be changed while running are applied
+// by the user interface.
+func (w *configWatcher) reload() context.ReloadedConfigMessage {
+	log.Printf("Config file changed, reloading it")
+	reloaded, err := readProfiles(w.options)
+	if err != nil {
+		log.Printf("Error when reloading config: %v", err)
+		return context.ReloadedConfigMessage{Err: err}
+	}
+	var message context.ReloadedConfigMessage
+	profiles := append([]profile(nil), w.profiles...)
+	overrides := make([]projectOverrides, len(profiles))
+	for i, profile := range profiles {
+		config := reloaded[i].config
+		redactSecrets(config)
+		live, restartRequired := changedEntries(profile.read, config)
+		profiles[i].config = withLive(profile.config, config)
+		profiles[i].read = withLive(profile.read, config)
+		overrides[i], err = checkLive(profiles[i].config)
+		if err != nil {
+			if len(profiles) > 1 || profile.name != defaultProfile {
+				err = fmt.Errorf("profile %v: %w", profile.name, err)
+			}
+			log.Printf("Error when reloading config: %v", err)
+			return context.ReloadedConfigMessage{Err: err}
+		}
+		message.Applied = append(message.Applied, profileEntries(profiles, profile, live)...)
+		message.RestartRequired = append(message.RestartRequired, profileEntries(profiles, profile, restartRequired)...)
+	}
+	log.Printf("Reloaded config, applied: %v, restart needed: %v", message.Applied, message.RestartRequired)
+	w.profiles = profiles
+	if len(message.Applied) == 0 {
+		return message
+	}
+	intervals := make([]int, len(profiles))
+	for i := range profiles {
+		intervals[i] = profiles[i].config.MergeJobIntervalSeconds
+	}
+	factories := w.factories
+	message.Apply = func(appContext *context.AppContext) {
+		for i, factory := range factories {
+			factory.reconfigure(appContext, profiles[i].config, overrides[i], intervals[i])
+		}
+		// settings of the whole application are taken from the first profile
+		config := profiles[0].config
+		appContext.SetRefreshInterval(config.RefreshIntervalSeconds)
+		appContext.SetMergeJobInterval(intervals[0], config.WebhookFallbackSeconds)
+		appContext.Styles = styles.NewStyles(theme(config.Theme))
+		// keys were checked when config was reloaded
+		_ = keys.Rebind(config.Keys)
+	}
+	return message
+}
+
+// checkLive validates config with reloaded entries, per project settings are parsed for projects factory.
+func checkLive(config *AppConfig) (projectOverrides, error) {
+	err := validateConfig(config)
+	if err != nil {
+		return projectOverrides{}, err
+	}
+	err = keys.CheckActions(config.Keys)
+	if err != nil {
+		return projectOverrides{}, fmt.Errorf("invalid keys config: %w", err)
+	}
+	return parseOverrides(config)
+}
+
+// changedEntries lists entries which differ, entries which can't be applied while running are listed separately.
+func changedEntries(running *AppConfig, reloaded *AppConfig) ([]string, []string) {
+	var live, restartRequired []string
+	runningValue, reloadedValue := reflect.ValueOf(running).Elem(), reflect.ValueOf(reloaded).Elem()
+	for i := 0; i < runningValue.NumField(); i++ {
+		if reflect.DeepEqual(runningValue.Field(i).Interface(), reloadedValue.Field(i).Interface()) {
+			continue
+		}
+		entry := runningValue.Type().Field(i).Tag.Get("koanf")
+		if isLive(entry, running, reloaded) {
+			live = append(live, entry)
+		} else {
+			restartRequired = append(restartRequired, entry)
+		}
+	}
+	return live, restartRequired
+}
+
+// withLive returns copy of config with entries which can be applied while running taken from reloaded config.
+func withLive(config *AppConfig, reloaded *AppConfig) *AppConfig {
+	result := *config
+	resultValue, reloadedValue := reflect.ValueOf(&result).Elem(), reflect.ValueOf(reloaded).Elem()
+	for i := 0; i < resultValue.NumField(); i++ {
+		if isLive(resultValue.Type().Field(i).Tag.Get("koanf"), config, reloaded) {
+			resultValue.Field(i).Set(reloadedValue.Field(i))
+		}
+	}
+	return &result
+}
+
+func isLive(entry string, running *AppConfig, reloaded *AppConfig) bool {
+	if entry == "projects" {
+		return sameProjects(running.Projects, reloaded.Projects)
+	}
+	return liveEntries[entry]
+}
+
+// sameProjects tells if sections list the same projects with the same policies, so that only branches differ.
+func sameProjects(running []ProjectConfig, reloaded []ProjectConfig) bool {
+	if len(running) != len(reloaded) {
+		return false
+	}
+	for i := range running {
+		if running[i].Name != reloaded[i].Name || !reflect.DeepEqual(running[i].Policies, reloaded[i].Policies) {
+			return false
+		}
+	}
+	return true
+}
+
+func profileEntries(profiles []profile, profile profile, entries []string) []string {
+	if len(profiles) == 1 {
+		return entries
+	}
+	var result []string
+	for _, entry := range entries {
+		result = append(result, profile.name+": "+entry)
+	}
+	return result
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/rawbytes"
+	"sort"
+	"strings"
+)
+
+// repositoryConfigFile is read from default branch of configured projects, its settings apply to everyone working on
+// the project unless their own config sets them.
+const repositoryConfigFile = ".mergemate.yml"
+
+// repositoryKeys are keys accepted in repository config file.
+var repositoryKeys = []string{"favorite_branches", "policies", "target_branch_prefixes"}
+
+type RepositoryConfig struct {
+	TargetBranchPrefixes []string               `koanf:"target_branch_prefixes"`
+	FavoriteBranches     []FavoriteBranchConfig `koanf:"favorite_branches"`
+	Policies             []PolicyConfig         `koanf:"policies"`
+	// Branch the file was read from, it's empty when project has no repository config
+	Branch string `koanf:"-"`
+}
+
+// readRepositoryConfig fetches repository config of client's project, empty config is returned when there is none.
+func readRepositoryConfig(client *gitlab.ApiClient) (RepositoryConfig, error) {
+	content, branch, err := client.RepositoryFile(repositoryConfigFile)
+	if err != nil || content == nil {
+		return RepositoryConfig{}, err
+	}
+	config, err := parseRepositoryConfig(content)
+	if err != nil {
+		return RepositoryConfig{}, fmt.Errorf("%v on branch %v: %w", repositoryConfigFile, branch, err)
+	}
+	config.Branch = branch
+	return config, nil
+}
+
+func parseRepositoryConfig(content []byte) (RepositoryConfig, error) {
+	k := koanf.New(".")
+	err := k.Load(rawbytes.Provider(content), yaml.Parser())
+	if err != nil {
+		return RepositoryConfig{}, err
+	}
+	var unknown []string
+	for key := range k.Raw() {
+		if !contains(repositoryKeys, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return RepositoryConfig{}, fmt.Errorf("unknown keys %v, known keys: %v", strings.Join(unknown, ", "), strings.Join(repositoryKeys, ", "))
+	}
+	var config RepositoryConfig
+	err = k.Unmarshal("", &config)
+	if err != nil {
+		return RepositoryConfig{}, err
+	}
+	return config, validatePolicyPatterns(config.Policies)
+}
+
+// repositorySource describes repository config in config sources.
+func repositorySource(config RepositoryConfig) string {
+	return fmt.Sprintf("%v on %v", repositoryConfigFile, config.Branch)
+}
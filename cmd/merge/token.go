@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/knadh/koanf"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenCommandTimeout leaves time to unlock password store when token command asks for a passphrase.
+const tokenCommandTimeout = time.Minute
+
+// commandTokens caches tokens printed by token commands, every command is run once per session. Mutex is held while
+// command runs, so profiles reloaded concurrently don't run it again or ask for a passphrase at the same time.
+var (
+	commandTokens      = make(map[string]string)
+	commandTokensMutex sync.Mutex
+)
+
+// resolveToken reads the token from MERGEMATE_API_TOKEN_FILE, prints it with MERGEMATE_API_TOKEN_COMMAND or takes
+// the one stored by login, token given directly takes precedence over the file, the file over the command and the
+// command over login. Session is returned when token was obtained with login.
+func resolveToken(config *AppConfig) (*gitlab.OAuthSession, error) {
+	switch {
+	case config.ApiToken != "":
+	case config.ApiTokenFile != "":
+		err := checkPrivate(config.ApiTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(config.ApiTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("MERGEMATE_API_TOKEN_FILE: %w", err)
+		}
+		config.ApiToken = strings.TrimSpace(string(content))
+		if config.ApiToken == "" {
+			return nil, fmt.Errorf("token file %v is empty", config.ApiTokenFile)
+		}
+	case config.ApiTokenCommand != "":
+		token, err := commandToken(config.ApiTokenCommand)
+		if err != nil {
+			return nil, fmt.Errorf("MERGEMATE_API_TOKEN_COMMAND: %w", err)
+		}
+		config.ApiToken = token
+	case config.OAuthApplicationId != "":
+		key := oauthTokenKey(config.GitlabUrl, config.UserName)
+		token, err := loadOAuthToken(key)
+		if err != nil || token == nil {
+			return nil, err
+		}
+		transport, err := newTransport(config)
+		if err != nil {
+			return nil, err
+		}
+		config.ApiToken = token.AccessToken
+		logRedactor.addSecret(token.RefreshToken)
+		app := gitlab.NewOAuthApp(config.GitlabUrl, config.OAuthApplicationId)
+		app.UseTransport(transport)
+		return gitlab.NewOAuthSession(app, *token, func(refreshed gitlab.OAuthToken) error {
+			logRedactor.addSecret(refreshed.AccessToken)
+			logRedactor.addSecret(refreshed.RefreshToken)
+			return saveOAuthToken(key, refreshed)
+		}), nil
+	}
+	return nil, nil
+}
+
+// commandToken runs command through system shell, first line of its output is the token.
+func commandToken(command string) (string, error) {
+	commandTokensMutex.Lock()
+	defer commandTokensMutex.Unlock()
+	if token, cached := commandTokens[command]; cached {
+		return token, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tokenCommandTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	// password managers may ask for a passphrase on the terminal
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	token, _, _ := strings.Cut(string(output), "\n")
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", errors.New("command printed no token")
+	}
+	commandTokens[command] = token
+	return token, nil
+}
+
+// checkPrivate fails when file can be read by group or other users, file permissions aren't checked on windows.
+func checkPrivate(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%v holds a secret and can be read by other users, restrict its permissions with: chmod 600 %v", path, path)
+	}
+	return nil
+}
+
+// checkConfigFile fails when config file holds secrets and can be read by other users.
+func checkConfigFile(fileConfig configFile) error {
+	if fileConfig.path == "" || !holdsSecrets(fileConfig.values) {
+		return nil
+	}
+	return checkPrivate(fileConfig.path)
+}
+
+func holdsSecrets(values *koanf.Koanf) bool {
+	for _, key := range values.Keys() {
+		// keys of profile sections are prefixed with profile name
+		if isSecretKey(key[strings.LastIndex(key, ".")+1:]) && values.String(key) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// redactingWriter replaces secrets in log output with a placeholder, secrets are added once they are known.
+type redactingWriter struct {
+	out     io.Writer
+	secrets []string
+	mutex   sync.Mutex
+}
+
+var logRedactor = &redactingWriter{out: os.Stderr}
+
+func (w *redactingWriter) addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.secrets = append(w.secrets, secret)
+}
+
+func (w *redactingWriter) setOutput(out io.Writer) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.out = out
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	redacted := p
+	for _, secret := range w.secrets {
+		redacted = bytes.ReplaceAll(redacted, []byte(secret), []byte(redactedValue))
+	}
+	_, err := w.out.Write(redacted)
+	return len(p), err
+}
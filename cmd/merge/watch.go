@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"github.com/aprokopczyk/mergemate/pkg/webhook"
+	"github.com/aprokopczyk/mergemate/ui/context"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchRecord is a state of watched merge request, a record is printed every time the state changes.
+type watchRecord struct {
+	Time        time.Time `json:"time"`
+	Project     string    `json:"project"`
+	Iid         int       `json:"iid"`
+	State       string    `json:"state"`
+	MergeStatus string    `json:"merge_status"`
+	Pipeline    string    `json:"pipeline"`
+	// Status and Reason are given by merge engine when merge request is merged automatically
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+var watchColumns = []string{"time", "project", "iid", "state", "merge_status", "pipeline", "status", "reason"}
+
+func (r watchRecord) row() []string {
+	return []string{r.Time.Format(time.RFC3339), r.Project, strconv.Itoa(r.Iid), r.State, r.MergeStatus, r.Pipeline, r.Status, r.Reason}
+}
+
+// sameState compares records regardless of their time.
+func (r watchRecord) sameState(other watchRecord) bool {
+	other.Time = r.Time
+	return r == other
+}
+
+// watchListener keeps the latest event of every merge request, events carry reasons of statuses.
+type watchListener struct {
+	mutex  sync.Mutex
+	events map[int]engine.Event
+}
+
+func (l *watchListener) Notify(event engine.Event) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.events[event.MergeRequestIid] = event
+}
+
+func (l *watchListener) reason(mergeRequestIid int, status string) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	event, exists := l.events[mergeRequestIid]
+	if !exists || event.Status != status {
+		return ""
+	}
+	return event.Reason
+}
+
+type watcher struct {
+	project      *context.Project
+	iid          int
+	sourceBranch string
+	automerge    bool
+	listener     *watchListener
+}
+
+// watchMergeRequest prints state of merge request until it's merged, it fails when merge request is closed, has
+// conflicts, its pipeline or merge fails or it isn't merged within --timeout. With --automerge merge request is merged
+// by merge engine of this command, the same way as background merge job does it.
+func watchMergeRequest(out io.Writer, options options, iid int) error {
+	listener := &watchListener{events: make(map[int]engine.Event)}
+	projects, err := loadCommandProjects(options, []engine.Listener{listener})
+	if err != nil {
+		return err
+	}
+	project, err := projects.project(options.project)
+	if err != nil {
+		return err
+	}
+	config := projects.factory(project).config
+	mergeRequest, err := project.GitlabClient.GetMergeRequestDetails(iid)
+	if err != nil {
+		return fmt.Errorf("fetching merge request !%v failed: %w", iid, err)
+	}
+	if options.automerge {
+		marked, err := project.MergeEngine.ShouldBeMergedAutomatically(*mergeRequest)
+		if err != nil {
+			return fmt.Errorf("fetching comments of merge request !%v failed: %w", iid, err)
+		}
+		// marker lets background merge job of a running mergemate continue when watch is stopped
+		if !marked {
+			_, err = project.GitlabClient.CreateMergeRequestNote(iid, engine.MergeAutomatically)
+			if err != nil {
+				return fmt.Errorf("marking merge request !%v to be merged automatically failed: %w", iid, err)
+			}
+		}
+	}
+	w := &watcher{
+		project:      project,
+		iid:          iid,
+		sourceBranch: mergeRequest.SourceBranch,
+		automerge:    options.automerge,
+		listener:     listener,
+	}
+
+	interval := time.Second * time.Duration(config.MergeJobMinIntervalSeconds)
+	fallback := time.Second * time.Duration(config.WebhookFallbackSeconds)
+	var server *webhook.Server
+	var wake <-chan struct{}
+	if config.WebhookListenAddress != "" {
+		server = webhook.New(webhook.Config{
+			ListenAddress: config.WebhookListenAddress,
+			Secret:        config.WebhookSecret,
+			Projects:      webhookProjects(config),
+			NamePrefix:    projects.factory(project).prefix,
+		})
+		err = server.Start()
+		if err != nil {
+			log.Printf("Error when starting webhook server, merge request will be polled every %v: %v", interval, err)
+			server = nil
+		} else {
+			defer server.Close()
+			wake = w.wakeOn(server.Events())
+		}
+	}
+	var timeout <-chan time.Time
+	if options.timeout > 0 {
+		timer := time.NewTimer(options.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	printer, err := newWatchPrinter(out, options.output)
+	if err != nil {
+		return err
+	}
+	var previous watchRecord
+	for {
+		current, done, err := w.check()
+		if !current.sameState(previous) {
+			printErr := printer.print(current)
+			if printErr != nil {
+				return printErr
+			}
+			previous = current
+		}
+		if done || err != nil {
+			return err
+		}
+		select {
+		case <-timeout:
+			return exitError{code: exitTimeout, err: fmt.Errorf("merge request !%v wasn't merged within %v, it is %v", iid, options.timeout, describeWatched(previous))}
+		case <-wake:
+		case <-time.After(pollInterval(server, interval, fallback)):
+		}
+	}
+}
+
+// pollInterval is the fallback interval while webhooks keep arriving, merge request is polled as usual otherwise.
+func pollInterval(server *webhook.Server, interval time.Duration, fallback time.Duration) time.Duration {
+	if server == nil {
+		return interval
+	}
+	lastEvent := server.LastEvent()
+	if !lastEvent.IsZero() && time.Since(lastEvent) < fallback {
+		return fallback
+	}
+	return interval
+}
+
+// wakeOn signals webhook events of watched merge request, pipeline events without merge request are matched by branch.
+func (w *watcher) wakeOn(events <-chan webhook.Event) <-chan struct{} {
+	wake := make(chan struct{}, 1)
+	go func() {
+		for event := range events {
+			if event.Project != w.project.Name {
+				continue
+			}
+			if event.MergeRequestIid == w.iid || (event.MergeRequestIid == 0 && event.SourceBranch == w.sourceBranch) {
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return wake
+}
+
+// check fetches state of merge request, done is set when merge request won't change anymore and err tells why it
+// wasn't merged. Failed requests are reported in the record and checked again later.
+func (w *watcher) check() (watchRecord, bool, error) {
+	record := watchRecord{Time: time.Now(), Project: w.project.Name, Iid: w.iid}
+	client := w.project.GitlabClient
+	mergeRequest, err := client.GetMergeRequestDetails(w.iid)
+	if err != nil {
+		record.Reason = fmt.Sprintf("fetching merge request failed: %v", err)
+		return record, false, nil
+	}
+	record.State = mergeRequest.State
+	record.MergeStatus = mergeRequest.DetailedMergeStatus
+	switch mergeRequest.State {
+	case "merged":
+		return record, true, nil
+	case "closed":
+		return record, true, fmt.Errorf("merge request !%v was closed", w.iid)
+	}
+	if w.automerge {
+		result := w.project.MergeEngine.Evaluate(map[int]bool{w.iid: true})
+		record.Status = result.Status[w.iid]
+		record.Reason = w.listener.reason(w.iid, record.Status)
+	}
+	pipelines, err := client.GetMergeRequestPipelines(w.iid)
+	if err != nil {
+		log.Printf("Error when fetching pipelines of merge request {id = %v}: %v", w.iid, err)
+	} else if len(pipelines) > 0 {
+		record.Pipeline = pipelines[0].Status
+	}
+	switch {
+	case record.Status == engine.StatusMerged:
+		record.State = "merged"
+		return record, true, nil
+	case record.Status == engine.StatusMergeConflict || mergeRequest.HasConflicts:
+		withReason(&record, fmt.Sprintf("source branch has conflicts with %v", mergeRequest.TargetBranch))
+		return record, true, exitError{code: exitConflict, err: fmt.Errorf("merge request !%v: %v", w.iid, record.Reason)}
+	case record.Status == engine.StatusMergeFailed:
+		withReason(&record, "gitlab didn't merge it")
+		return record, true, fmt.Errorf("merging merge request !%v failed: %v", w.iid, record.Reason)
+	case record.Status == engine.StatusCiFailed || record.Pipeline == "failed":
+		withReason(&record, "pipeline failed")
+		return record, true, fmt.Errorf("merge request !%v: %v", w.iid, record.Reason)
+	}
+	return record, false, nil
+}
+
+func withReason(record *watchRecord, reason string) {
+	if record.Reason == "" {
+		record.Reason = reason
+	}
+}
+
+func describeWatched(record watchRecord) string {
+	if record.Status != "" {
+		return strings.ToLower(record.Status)
+	}
+	if record.MergeStatus != "" {
+		return fmt.Sprintf("%v (%v)", record.State, record.MergeStatus)
+	}
+	return record.State
+}
+
+// watchPrinter streams records, JSON is printed as one object per line and table has no aligned columns, as records
+// aren't known upfront.
+type watchPrinter struct {
+	out    io.Writer
+	format string
+	csv    *csv.Writer
+}
+
+func newWatchPrinter(out io.Writer, format string) (*watchPrinter, error) {
+	printer := &watchPrinter{out: out, format: format}
+	switch format {
+	case outputCsv:
+		printer.csv = csv.NewWriter(out)
+		return printer, printer.writeCsv(watchColumns)
+	case outputTable:
+		_, err := fmt.Fprintln(out, strings.ToUpper(strings.Join(watchColumns, "  ")))
+		return printer, err
+	}
+	return printer, nil
+}
+
+func (p *watchPrinter) print(record watchRecord) error {
+	switch p.format {
+	case outputJson:
+		return json.NewEncoder(p.out).Encode(record)
+	case outputCsv:
+		return p.writeCsv(record.row())
+	default:
+		cells := record.row()
+		// tabs and line breaks of reasons would break lines
+		replacer := strings.NewReplacer("\t", " ", "\n", " ")
+		for i, cell := range cells {
+			cells[i] = replacer.Replace(cell)
+			if cell == "" {
+				cells[i] = "-"
+			}
+		}
+		_, err := fmt.Fprintln(p.out, strings.Join(cells, "  "))
+		return err
+	}
+}
+
+func (p *watchPrinter) writeCsv(row []string) error {
+	err := p.csv.Write(row)
+	if err != nil {
+		return err
+	}
+	p.csv.Flush()
+	return p.csv.Error()
+}
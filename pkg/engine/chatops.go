@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const commandPrefix = "/mergemate"
+const commandsUsage = "available commands: `/mergemate merge`, `/mergemate cancel`, `/mergemate rebase`, `/mergemate backport <target branch>`"
+
+var acknowledgementMarker = regexp.MustCompile(`<!-- mergemate-ack:(\d+) -->`)
+
+type cachedMarker struct {
+	updatedAt      time.Time
+	shouldBeMerged bool
+}
+
+type command struct {
+	name string
+	args []string
+}
+
+type commandsOutcome struct {
+	mergeAutomaticallyChanged bool
+	shouldBeMerged            bool
+	rebased                   bool
+}
+
+func (outcome commandsOutcome) mergeAutomatically() (bool, bool) {
+	return outcome.shouldBeMerged, outcome.mergeAutomaticallyChanged
+}
+
+func parseCommand(body string) (command, bool) {
+	firstLine := strings.SplitN(strings.TrimSpace(body), "\n", 2)[0]
+	fields := strings.Fields(firstLine)
+	if len(fields) == 0 || fields[0] != commandPrefix {
+		return command{}, false
+	}
+	if len(fields) == 1 {
+		return command{}, true
+	}
+	return command{name: fields[1], args: fields[2:]}, true
+}
+
+func (e *Engine) isAuthorised(userName string) bool {
+	return e.chatOpsUsers[userName]
+}
+
+// IsMarkedForAutomaticMerge replays marker notes and authorised merge/cancel commands in the order they were written.
+func (e *Engine) IsMarkedForAutomaticMerge(notes []gitlab.MergeRequestNote) bool {
+	var shouldBeMerged bool
+	for _, note := range notes {
+		if strings.HasPrefix(note.Body, MergeAutomatically) {
+			shouldBeMerged = true
+			continue
+		}
+		cmd, ok := parseCommand(note.Body)
+		if !ok || note.System || !e.isAuthorised(note.Author.Username) {
+			continue
+		}
+		switch cmd.name {
+		case "merge":
+			shouldBeMerged = true
+		case "cancel":
+			shouldBeMerged = false
+		}
+	}
+	return shouldBeMerged
+}
+
+// ShouldBeMergedAutomatically checks notes of merge request, result is reused until merge request is updated.
+func (e *Engine) ShouldBeMergedAutomatically(mergeRequest gitlab.MergeRequestDetails) (bool, error) {
+	e.mutex.Lock()
+	cached, exists := e.markers[mergeRequest.Iid]
+	e.mutex.Unlock()
+	if exists && !mergeRequest.UpdatedAt.IsZero() && cached.updatedAt.Equal(mergeRequest.UpdatedAt) {
+		return cached.shouldBeMerged, nil
+	}
+	notes, err := e.client.CachedMergeRequestNotes(mergeRequest)
+	if err != nil {
+		return false, err
+	}
+	shouldBeMerged := e.IsMarkedForAutomaticMerge(notes)
+	e.mutex.Lock()
+	e.markers[mergeRequest.Iid] = cachedMarker{updatedAt: mergeRequest.UpdatedAt, shouldBeMerged: shouldBeMerged}
+	e.mutex.Unlock()
+	return shouldBeMerged, nil
+}
+
+func (e *Engine) acknowledgedNotes(notes []gitlab.MergeRequestNote) map[int]bool {
+	acknowledged := make(map[int]bool)
+	for _, note := range notes {
+		if note.Author.Username != e.userName {
+			continue
+		}
+		for _, match := range acknowledgementMarker.FindAllStringSubmatch(note.Body, -1) {
+			noteId, err := strconv.Atoi(match[1])
+			if err == nil {
+				acknowledged[noteId] = true
+			}
+		}
+	}
+	return acknowledged
+}
+
+func (e *Engine) handleCommands(mergeRequest *gitlab.MergeRequestDetails, notes []gitlab.MergeRequestNote, result *Result) commandsOutcome {
+	var outcome commandsOutcome
+	acknowledged := e.acknowledgedNotes(notes)
+	for _, note := range notes {
+		cmd, ok := parseCommand(note.Body)
+		if !ok || note.System || acknowledged[note.Id] || !e.markHandled(note.Id) {
+			continue
+		}
+		log.Printf("Executing command '%v' from @%v on merge request {id = %v, title=%v}.", strings.TrimSpace(note.Body), note.Author.Username, mergeRequest.Iid, mergeRequest.Title)
+		reply := e.executeCommand(mergeRequest, note, cmd, &outcome, result)
+		_, err := e.client.CreateMergeRequestNote(mergeRequest.Iid, fmt.Sprintf("mergemate: %s\n\n<!-- mergemate-ack:%d -->", reply, note.Id))
+		if err != nil {
+			log.Printf("Error when acknowledging command on merge request {id = %v}: %v", mergeRequest.Iid, err)
+		}
+	}
+	if outcome.mergeAutomaticallyChanged {
+		result.MergeAutomatically[mergeRequest.Iid] = outcome.shouldBeMerged
+	}
+	return outcome
+}
+
+func (e *Engine) markHandled(noteId int) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.handledNotes[noteId] {
+		return false
+	}
+	e.handledNotes[noteId] = true
+	return true
+}
+
+func (e *Engine) executeCommand(mergeRequest *gitlab.MergeRequestDetails, note gitlab.MergeRequestNote, cmd command, outcome *commandsOutcome, result *Result) string {
+	author := note.Author.Username
+	if !e.isAuthorised(author) {
+		return fmt.Sprintf("@%s is not allowed to control this merge request.", author)
+	}
+	switch cmd.name {
+	case "merge":
+		outcome.mergeAutomaticallyChanged = true
+		outcome.shouldBeMerged = true
+		result.Actions = append(result.Actions, fmt.Sprintf("@%s enabled automatic merge of '%s'", author, mergeRequest.Title))
+		return "merge request will be merged automatically once it is ready."
+	case "cancel":
+		outcome.mergeAutomaticallyChanged = true
+		outcome.shouldBeMerged = false
+		result.Actions = append(result.Actions, fmt.Sprintf("@%s disabled automatic merge of '%s'", author, mergeRequest.Title))
+		return "automatic merge cancelled."
+	case "rebase":
+		if mergeRequest.RebaseInProgress {
+			return "rebase is already in progress."
+		}
+		err := e.Rebase(mergeRequest)
+		if err != nil {
+			log.Printf("Error when rebasing merge request {id = %v}: %v", mergeRequest.Iid, err)
+			return "rebase failed, please check the merge request."
+		}
+		outcome.rebased = true
+		result.Actions = append(result.Actions, fmt.Sprintf("@%s requested rebase of '%s'", author, mergeRequest.Title))
+		return "rebase started."
+	case "backport":
+		if len(cmd.args) != 1 {
+			return "usage: `/mergemate backport <target branch>`"
+		}
+		return e.backport(mergeRequest, cmd.args[0], author, result)
+	default:
+		return "unknown command, " + commandsUsage + "."
+	}
+}
+
+func (e *Engine) backport(mergeRequest *gitlab.MergeRequestDetails, targetBranch string, author string, result *Result) string {
+	backport, err := e.client.CreateMergeRequest(mergeRequest.SourceBranch, targetBranch, mergeRequest.Title)
+	if errors.Is(err, gitlab.MergeRequestAlreadyExists) {
+		return fmt.Sprintf("merge request from %s to %s already exists.", mergeRequest.SourceBranch, targetBranch)
+	} else if err != nil {
+		log.Printf("Error when creating backport of merge request {id = %v} to %v: %v", mergeRequest.Iid, targetBranch, err)
+		return fmt.Sprintf("backport to %s failed, please check the target branch name.", targetBranch)
+	}
+	_, err = e.client.CreateMergeRequestNote(backport.Iid, MergeAutomatically)
+	if err != nil {
+		log.Printf("Error when marking merge request to be merged automatically %v", err)
+	}
+	result.Actions = append(result.Actions, fmt.Sprintf("@%s created backport of '%s' to %s", author, mergeRequest.Title, targetBranch))
+	return fmt.Sprintf("created backport !%d to %s, it will be merged automatically.", backport.Iid, targetBranch)
+}
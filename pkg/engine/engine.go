@@ -0,0 +1,380 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const MergeAutomatically = "MERGE_AUTOMATICALLY"
+
+// CancelMergeAutomatically is a command which turns off automatic merge enabled with MergeAutomatically note.
+const CancelMergeAutomatically = commandPrefix + " cancel"
+
+const (
+	StatusRebaseInProgress = "Rebase in progress"
+	StatusMergeConflict    = "Merge conflict"
+	StatusCiRunning        = "CI running"
+	StatusCiFailed         = "CI failed"
+	StatusNeedsRebase      = "Needs rebase"
+	StatusMergeFailed      = "Merge failed"
+	StatusMerged           = "Merged"
+	StatusReadyToMerge     = "Ready to merge"
+)
+
+const (
+	actionRebase      = "Rebased source branch onto target branch"
+	actionMerge       = "Merged merge request"
+	actionMergeFailed = "Tried to merge merge request"
+	actionGaveUp      = "Gave up rebasing"
+)
+
+type Config struct {
+	UserName     string
+	ChatOpsUsers []string
+	StatusNotes  bool
+	Listeners    []Listener
+	Interval     time.Duration
+	MinInterval  time.Duration
+	MaxInterval  time.Duration
+	Parallelism  int
+	// RequestBudget limits number of requests sent per minute, 0 means no limit
+	RequestBudget int
+	Policies      []Policy
+}
+
+type Engine struct {
+	client        *gitlab.ApiClient
+	userName      string
+	chatOpsUsers  map[string]bool
+	statusNotes   bool
+	handledNotes  map[int]bool
+	published     map[int]publishedStatus
+	markers       map[int]cachedMarker
+	statuses      map[int]string
+	listeners     []Listener
+	schedules     map[int]schedule
+	tracked       map[int]bool
+	interval      time.Duration
+	minInterval   time.Duration
+	maxInterval   time.Duration
+	requestBudget int
+	// requestsPerEvaluation estimates number of requests sent to evaluate a merge request
+	requestsPerEvaluation int
+	parallelism           int
+	policies              []Policy
+	mutex                 sync.Mutex
+	// running serializes merge job and evaluations triggered by webhooks, so a merge request isn't rebased or merged twice
+	running sync.Mutex
+	// stopped is set by Stop, it's guarded by running
+	stopped bool
+}
+
+type Result struct {
+	Status             map[int]string
+	MergeAutomatically map[int]bool
+	Actions            []string
+	NextRun            time.Duration
+}
+
+// report describes outcome of a single merge request evaluation, status is empty when nothing could be determined.
+type report struct {
+	status string
+	action string
+	reason string
+	rebase bool
+	// pipelineRemaining is estimated time left until running pipeline finishes, valid when pipelineEstimated is set
+	pipelineRemaining time.Duration
+	pipelineEstimated bool
+}
+
+func New(client *gitlab.ApiClient, config Config) *Engine {
+	authorisedUsers := map[string]bool{config.UserName: true}
+	for _, user := range config.ChatOpsUsers {
+		if user != "" {
+			authorisedUsers[user] = true
+		}
+	}
+	engine := &Engine{
+		client:                client,
+		userName:              config.UserName,
+		chatOpsUsers:          authorisedUsers,
+		statusNotes:           config.StatusNotes,
+		handledNotes:          make(map[int]bool),
+		published:             make(map[int]publishedStatus),
+		markers:               make(map[int]cachedMarker),
+		statuses:              make(map[int]string),
+		listeners:             config.Listeners,
+		schedules:             make(map[int]schedule),
+		tracked:               make(map[int]bool),
+		interval:              config.Interval,
+		minInterval:           config.MinInterval,
+		maxInterval:           config.MaxInterval,
+		requestBudget:         config.RequestBudget,
+		requestsPerEvaluation: minRequestsPerEvaluation,
+		parallelism:           config.Parallelism,
+		policies:              config.Policies,
+	}
+	if engine.parallelism <= 0 {
+		engine.parallelism = 1
+	}
+	return engine
+}
+
+// Track replaces merge requests handled by the merge job, value tells whether merge request should be merged automatically.
+func (e *Engine) Track(mergeRequests map[int]bool) {
+	tracked := make(map[int]bool)
+	for mergeRequestIid, shouldBeMerged := range mergeRequests {
+		tracked[mergeRequestIid] = shouldBeMerged
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.tracked = tracked
+}
+
+// ProcessTracked evaluates tracked merge requests which are due according to their schedules.
+func (e *Engine) ProcessTracked() Result {
+	e.mutex.Lock()
+	tracked := make(map[int]bool)
+	for mergeRequestIid, shouldBeMerged := range e.tracked {
+		tracked[mergeRequestIid] = shouldBeMerged
+	}
+	e.mutex.Unlock()
+	return e.Process(tracked)
+}
+
+// Stop waits until running evaluation finishes, merge requests aren't evaluated and listeners aren't notified afterwards.
+func (e *Engine) Stop() {
+	e.running.Lock()
+	defer e.running.Unlock()
+	e.stopped = true
+}
+
+// Process evaluates merge requests which are due according to their schedules.
+func (e *Engine) Process(mergeRequests map[int]bool) Result {
+	return e.process(mergeRequests, false)
+}
+
+// Evaluate evaluates given merge requests immediately, regardless of their schedules.
+func (e *Engine) Evaluate(mergeRequests map[int]bool) Result {
+	return e.process(mergeRequests, true)
+}
+
+// evaluation collects everything learned about single merge request by one of the workers.
+type evaluation struct {
+	evaluated      bool
+	mergeRequest   *gitlab.MergeRequestDetails
+	notes          []gitlab.MergeRequestNote
+	shouldBeMerged bool
+	current        report
+	commands       Result
+}
+
+func (e *Engine) process(mergeRequests map[int]bool, force bool) Result {
+	e.running.Lock()
+	defer e.running.Unlock()
+	if e.stopped {
+		return Result{}
+	}
+	// data prefetched for an earlier run may be stale, it's replaced by Prefetch or not used at all
+	e.client.ClearPrefetch()
+	defer e.client.ClearPrefetch()
+	if health, suspended := e.client.Health(); suspended > 0 {
+		log.Printf("Gitlab is %v, merge requests will be processed in %v", health, suspended)
+		return Result{NextRun: suspended}
+	}
+	due := e.dueMergeRequests(mergeRequests, time.Now(), force)
+	log.Printf("Processing merge requests: %v", due)
+	if len(due) > 0 && !force {
+		err := e.client.Prefetch()
+		if err != nil {
+			log.Printf("Error when prefetching merge requests, falling back to rest api: %v", err)
+		}
+	}
+	result := Result{
+		Status:             make(map[int]string),
+		MergeAutomatically: make(map[int]bool),
+	}
+	sentBefore := e.client.RequestsSent()
+	evaluations, started, budgetExhausted := e.evaluateAll(due, mergeRequests, force)
+	// requests are counted once rebases and status notes are done as well
+	defer func() { e.updateRequestEstimate(e.client.RequestsSent()-sentBefore, started) }()
+
+	var rebasing []*gitlab.MergeRequestDetails
+	for _, evaluated := range evaluations {
+		if !evaluated.evaluated {
+			continue
+		}
+		mergeRequestIid := evaluated.mergeRequest.Iid
+		current := evaluated.current
+		for mrIid, shouldBeMerged := range evaluated.commands.MergeAutomatically {
+			result.MergeAutomatically[mrIid] = shouldBeMerged
+		}
+		result.Actions = append(result.Actions, evaluated.commands.Actions...)
+		if current.rebase {
+			// we will rebase after evaluation, in case there is mr that could be merged, we'll need to rebase only once
+			rebasing = append(rebasing, evaluated.mergeRequest)
+		}
+		if current.status != "" {
+			result.Status[mergeRequestIid] = current.status
+			eventType, notifiable := statusEvents[current.status]
+			if e.shouldNotify(mergeRequestIid, current.status) && notifiable {
+				e.emit(newEvent(eventType, evaluated.mergeRequest, current, evaluated.shouldBeMerged))
+			}
+		}
+		e.reschedule(mergeRequestIid, current, evaluated.shouldBeMerged)
+	}
+
+	for _, mergeRequest := range rebasing {
+		err := e.Rebase(mergeRequest)
+		if err != nil {
+			log.Printf("Error when rebasing merge request {id = %v}: %v", mergeRequest.Iid, err)
+			continue
+		}
+		e.emit(newEvent(EventRebased, mergeRequest, report{status: StatusRebaseInProgress, action: actionRebase}, true))
+	}
+
+	if e.statusNotes {
+		for _, evaluated := range evaluations {
+			if evaluated.evaluated && evaluated.shouldBeMerged {
+				e.publishStatus(evaluated.mergeRequest.Iid, evaluated.notes, evaluated.current)
+			}
+		}
+	}
+
+	if !force {
+		result.NextRun = e.nextRun(mergeRequests, budgetExhausted)
+	}
+	return result
+}
+
+// evaluateAll evaluates due merge requests using a pool of workers, results are stored in the order of due merge requests.
+// Merge requests which weren't started before the job interval passed or request budget run out are left for the next run,
+// requests of evaluations which are still running are reserved in the budget.
+func (e *Engine) evaluateAll(due []int, mergeRequests map[int]bool, force bool) ([]evaluation, int, bool) {
+	evaluations := make([]evaluation, len(due))
+	interval := e.jobInterval()
+	deadline := time.Now().Add(interval)
+	indexes := make(chan int)
+	var workers sync.WaitGroup
+	var finished int32
+	for i := 0; i < e.parallelism; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range indexes {
+				evaluations[index] = e.evaluateMergeRequest(due[index], mergeRequests[due[index]])
+				atomic.AddInt32(&finished, 1)
+			}
+		}()
+	}
+
+	budgetExhausted := false
+	started := 0
+	for index := range due {
+		if !force && time.Now().After(deadline) {
+			log.Printf("Merge job overran its interval of %v, remaining %v merge requests will be evaluated in the next run.", interval, len(due)-index)
+			break
+		}
+		running := started - int(atomic.LoadInt32(&finished))
+		if !e.withinBudget(running + 1) {
+			budgetExhausted = true
+			break
+		}
+		indexes <- index
+		started++
+	}
+	close(indexes)
+	workers.Wait()
+	return evaluations, started, budgetExhausted
+}
+
+func (e *Engine) evaluateMergeRequest(mergeRequestIid int, shouldBeMerged bool) evaluation {
+	mergeRequest, err := e.client.GetMergeRequestDetails(mergeRequestIid)
+	if err != nil {
+		log.Printf("Fetching merge request details failed %v", err)
+		e.postpone(mergeRequestIid, e.jobInterval())
+		return evaluation{}
+	}
+	notes, err := e.client.CachedMergeRequestNotes(*mergeRequest)
+	if err != nil {
+		log.Printf("Error when fetching notes of merge request {id = %v, title=%v}: %v", mergeRequestIid, mergeRequest.Title, err)
+	}
+	commands := Result{MergeAutomatically: make(map[int]bool)}
+	outcome := e.handleCommands(mergeRequest, notes, &commands)
+	if merge, changed := outcome.mergeAutomatically(); changed {
+		shouldBeMerged = merge
+	}
+
+	var current report
+	if outcome.rebased {
+		current = report{status: StatusRebaseInProgress, action: actionRebase, reason: "rebase was requested in a comment"}
+	} else {
+		current = e.evaluate(mergeRequest, shouldBeMerged)
+	}
+	return evaluation{
+		evaluated:      true,
+		mergeRequest:   mergeRequest,
+		notes:          notes,
+		shouldBeMerged: shouldBeMerged,
+		current:        current,
+		commands:       commands,
+	}
+}
+
+func (e *Engine) evaluate(mergeRequest *gitlab.MergeRequestDetails, shouldBeMerged bool) report {
+	mergeRequestIid := mergeRequest.Iid
+	if mergeRequest.RebaseInProgress {
+		log.Printf("Merge request {id = %v, title=%v} is being rebased.", mergeRequest.Iid, mergeRequest.Title)
+		return report{status: StatusRebaseInProgress, reason: "source branch is being rebased"}
+	} else if mergeRequest.RebaseError != "" && mergeRequest.HasConflicts {
+		return report{status: StatusMergeConflict, action: actionGaveUp, reason: mergeRequest.RebaseError}
+	}
+	pipelines, err := e.client.GetMergeRequestPipelines(mergeRequestIid)
+	if err != nil {
+		log.Printf("Error when fetching pipeline for merge request{id = %v, title=%v}: %v", mergeRequestIid, mergeRequest.Title, err)
+	}
+	if gitlab.IsPipelineRunning(pipelines) {
+		remaining, estimated := gitlab.EstimateRemainingDuration(pipelines)
+		return report{status: StatusCiRunning, reason: "waiting for pipeline to finish", pipelineRemaining: remaining, pipelineEstimated: estimated}
+	}
+	if len(pipelines) > 0 && pipelines[0].Status == "failed" {
+		return report{status: StatusCiFailed, reason: fmt.Sprintf("pipeline #%d failed", pipelines[0].Id)}
+	}
+	isBehindTargetBranch := mergeRequest.CommitsBehind > 0
+	behindReason := fmt.Sprintf("source branch is %d commits behind %s", mergeRequest.CommitsBehind, mergeRequest.TargetBranch)
+	if shouldBeMerged && isBehindTargetBranch && !e.policy(mergeRequest.TargetBranch).Rebase {
+		return report{status: StatusNeedsRebase, reason: behindReason + ", policy of the target branch doesn't allow rebasing"}
+	} else if shouldBeMerged && isBehindTargetBranch {
+		log.Printf("Merge request {id = %v, title=%v} is behind target branch by %v commits, it will be rebased.", mergeRequestIid, mergeRequest.Title, mergeRequest.CommitsBehind)
+		return report{status: StatusRebaseInProgress, action: actionRebase, reason: behindReason, rebase: true}
+	} else if isBehindTargetBranch {
+		return report{status: StatusNeedsRebase, reason: behindReason}
+	}
+	automaticMergeAllowed := gitlab.IsAutomaticMergeAllowed(pipelines)
+	if shouldBeMerged && automaticMergeAllowed {
+		// hurray, we can merge it!
+		log.Printf("Merging merge request {id = %v, title=%v}.", mergeRequestIid, mergeRequest.Title)
+		// we pass sha to make sure that nothing was pushed in the meantime
+		request, err := e.client.MergeMergeRequest(mergeRequestIid, mergeRequest.Sha)
+		if err != nil {
+			log.Printf("Error when merging merge request {id = %v, title=%v}: %v ", mergeRequestIid, mergeRequest.Title, err)
+			return report{status: StatusMergeFailed, action: actionMergeFailed, reason: err.Error()}
+		}
+		if request.State == "merged" {
+			log.Printf("Merged merge request {id = %v, title=%v}.", mergeRequestIid, mergeRequest.Title)
+			return report{status: StatusMerged, action: actionMerge, reason: "pipeline succeeded"}
+		}
+		return report{action: actionMergeFailed, reason: fmt.Sprintf("merge request is %s", request.State)}
+	} else if automaticMergeAllowed {
+		return report{status: StatusReadyToMerge, reason: "pipeline succeeded"}
+	}
+	// status is left empty, there is nothing to merge yet, but the reason is still published in status note
+	if len(pipelines) == 0 {
+		return report{reason: "no pipeline was found"}
+	}
+	return report{reason: fmt.Sprintf("pipeline #%d is %s", pipelines[0].Id, pipelines[0].Status)}
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"time"
+)
+
+const (
+	EventMerged        = "merged"
+	EventMergeFailed   = "merge_failed"
+	EventCiFailed      = "ci_failed"
+	EventMergeConflict = "merge_conflict"
+	EventRebased       = "rebased"
+	EventReadyToMerge  = "ready_to_merge"
+)
+
+var EventTypes = []string{EventMerged, EventMergeFailed, EventCiFailed, EventMergeConflict, EventRebased, EventReadyToMerge}
+
+// statusEvents maps statuses to events emitted when merge request enters them.
+var statusEvents = map[string]string{
+	StatusMerged:        EventMerged,
+	StatusMergeFailed:   EventMergeFailed,
+	StatusCiFailed:      EventCiFailed,
+	StatusMergeConflict: EventMergeConflict,
+	StatusReadyToMerge:  EventReadyToMerge,
+}
+
+type Event struct {
+	Type               string    `json:"type"`
+	Project            string    `json:"project"`
+	MergeRequestIid    int       `json:"merge_request_iid"`
+	Title              string    `json:"title"`
+	WebUrl             string    `json:"web_url"`
+	SourceBranch       string    `json:"source_branch"`
+	TargetBranch       string    `json:"target_branch"`
+	Status             string    `json:"status"`
+	Action             string    `json:"action"`
+	Reason             string    `json:"reason"`
+	MergeAutomatically bool      `json:"merge_automatically"`
+	Time               time.Time `json:"time"`
+}
+
+type Listener interface {
+	Notify(event Event)
+}
+
+func newEvent(eventType string, mergeRequest *gitlab.MergeRequestDetails, current report, shouldBeMerged bool) Event {
+	return Event{
+		Type:               eventType,
+		MergeRequestIid:    mergeRequest.Iid,
+		Title:              mergeRequest.Title,
+		WebUrl:             mergeRequest.WebUrl,
+		SourceBranch:       mergeRequest.SourceBranch,
+		TargetBranch:       mergeRequest.TargetBranch,
+		Status:             current.status,
+		Action:             current.action,
+		Reason:             current.reason,
+		MergeAutomatically: shouldBeMerged,
+		Time:               time.Now(),
+	}
+}
+
+// statusChanged remembers the latest status of merge request and reports whether it differs from the previous one,
+// known is false when merge request is seen for the first time, i.e. after restart.
+func (e *Engine) statusChanged(mergeRequestIid int, status string) (changed bool, known bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	previous, exists := e.statuses[mergeRequestIid]
+	e.statuses[mergeRequestIid] = status
+	return exists && previous != status, exists
+}
+
+// shouldNotify tells whether event of a status is sent, statuses seen for the first time are sent only when they are
+// result of merge done by this evaluation, so that restart doesn't repeat notifications of merge requests.
+func (e *Engine) shouldNotify(mergeRequestIid int, status string) bool {
+	changed, known := e.statusChanged(mergeRequestIid, status)
+	if !known {
+		return status == StatusMerged || status == StatusMergeFailed
+	}
+	return changed
+}
+
+func (e *Engine) emit(event Event) {
+	event.Project = e.client.ProjectName()
+	for _, listener := range e.listeners {
+		listener.Notify(event)
+	}
+}
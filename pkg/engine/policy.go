@@ -0,0 +1,38 @@
+package engine
+
+import (
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"log"
+	"path"
+)
+
+// Policy changes how merge requests to matching target branches are handled.
+type Policy struct {
+	// TargetBranch is a glob pattern, i.e. release/*, the first matching policy is used
+	TargetBranch string
+	// Rebase allows rebasing source branches which are behind their target branch
+	Rebase bool
+	// SkipCi skips pipeline started by rebase, pipeline of the merge request is run anyway
+	SkipCi bool
+}
+
+var defaultPolicy = Policy{Rebase: true, SkipCi: true}
+
+func (e *Engine) policy(targetBranch string) Policy {
+	for _, policy := range e.policies {
+		matches, err := path.Match(policy.TargetBranch, targetBranch)
+		if err != nil {
+			log.Printf("Invalid target branch pattern %v of policy: %v", policy.TargetBranch, err)
+			continue
+		}
+		if matches {
+			return policy
+		}
+	}
+	return defaultPolicy
+}
+
+// Rebase rebases source branch of merge request, pipeline is skipped according to policy of its target branch.
+func (e *Engine) Rebase(mergeRequest *gitlab.MergeRequestDetails) error {
+	return e.client.RebaseMergeRequest(mergeRequest.Iid, e.policy(mergeRequest.TargetBranch).SkipCi)
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// minRequestsPerEvaluation is a number of requests needed to evaluate single merge request: details, notes and
+// pipelines. Merges, rebases and comments need more, so the estimate is updated with requests counted by the client.
+const minRequestsPerEvaluation = 3
+
+type schedule struct {
+	due        time.Time
+	idleRounds int
+	status     string
+}
+
+// dueMergeRequests returns merge requests that should be evaluated now, the most overdue first.
+func (e *Engine) dueMergeRequests(mergeRequests map[int]bool, now time.Time, force bool) []int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	var due []int
+	for mergeRequestIid := range mergeRequests {
+		entry, exists := e.schedules[mergeRequestIid]
+		if force || !exists || !entry.due.After(now) {
+			due = append(due, mergeRequestIid)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		left, right := e.schedules[due[i]].due, e.schedules[due[j]].due
+		if left.Equal(right) {
+			return due[i] < due[j]
+		}
+		return left.Before(right)
+	})
+	return due
+}
+
+// SetIntervals changes intervals of the merge job, merge requests already scheduled keep their due time.
+func (e *Engine) SetIntervals(interval time.Duration, minInterval time.Duration, maxInterval time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.interval = interval
+	e.minInterval = minInterval
+	e.maxInterval = maxInterval
+}
+
+func (e *Engine) jobInterval() time.Duration {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.interval
+}
+
+// withinBudget tells whether evaluations can be started without exceeding request budget.
+func (e *Engine) withinBudget(evaluations int) bool {
+	if e.requestBudget <= 0 {
+		return true
+	}
+	e.mutex.Lock()
+	requestsPerEvaluation := e.requestsPerEvaluation
+	e.mutex.Unlock()
+	return e.client.RequestsInLastMinute()+evaluations*requestsPerEvaluation <= e.requestBudget
+}
+
+// updateRequestEstimate averages the estimate with requests sent for evaluations of the last run, including rebases,
+// merges and comments they led to.
+func (e *Engine) updateRequestEstimate(requests int, evaluations int) {
+	if evaluations == 0 {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	perEvaluation := (requests + evaluations - 1) / evaluations
+	e.requestsPerEvaluation = (e.requestsPerEvaluation + perEvaluation + 1) / 2
+	if e.requestsPerEvaluation < minRequestsPerEvaluation {
+		e.requestsPerEvaluation = minRequestsPerEvaluation
+	}
+}
+
+// reschedule picks time of the next evaluation: rebases and pipelines close to finishing are checked often,
+// merge requests that won't be merged automatically are checked less and less often while nothing changes.
+func (e *Engine) reschedule(mergeRequestIid int, current report, shouldBeMerged bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	entry := e.schedules[mergeRequestIid]
+	if shouldBeMerged || entry.status != current.status {
+		entry.idleRounds = 0
+	}
+	var next time.Duration
+	switch {
+	case current.status == StatusRebaseInProgress:
+		next = e.minInterval
+	case current.status == StatusCiRunning && current.pipelineEstimated:
+		next = clamp(current.pipelineRemaining, e.minInterval, e.interval)
+	case !shouldBeMerged:
+		next = e.interval << uint(entry.idleRounds)
+		if next > e.maxInterval || next <= 0 {
+			next = e.maxInterval
+		} else {
+			entry.idleRounds++
+		}
+	default:
+		next = e.interval
+	}
+	entry.status = current.status
+	entry.due = time.Now().Add(next)
+	e.schedules[mergeRequestIid] = entry
+}
+
+func (e *Engine) postpone(mergeRequestIid int, delay time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	entry := e.schedules[mergeRequestIid]
+	entry.due = time.Now().Add(delay)
+	e.schedules[mergeRequestIid] = entry
+}
+
+// nextRun returns delay after which the earliest of given merge requests is due, schedules of other merge requests are dropped.
+func (e *Engine) nextRun(mergeRequests map[int]bool, budgetExhausted bool) time.Duration {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if budgetExhausted {
+		log.Printf("Request budget of %v requests per minute exhausted, postponing evaluation of remaining merge requests.", e.requestBudget)
+		return e.minInterval
+	}
+	for mergeRequestIid := range e.schedules {
+		if _, tracked := mergeRequests[mergeRequestIid]; !tracked {
+			delete(e.schedules, mergeRequestIid)
+		}
+	}
+	if len(mergeRequests) == 0 {
+		return e.minInterval
+	}
+	next := e.interval
+	now := time.Now()
+	for mergeRequestIid := range mergeRequests {
+		entry, exists := e.schedules[mergeRequestIid]
+		if !exists {
+			return e.minInterval
+		}
+		if untilDue := entry.due.Sub(now); untilDue < next {
+			next = untilDue
+		}
+	}
+	return clamp(next, e.minInterval, e.interval)
+}
+
+func clamp(value time.Duration, lower time.Duration, upper time.Duration) time.Duration {
+	if value < lower {
+		return lower
+	}
+	if value > upper {
+		return upper
+	}
+	return value
+}
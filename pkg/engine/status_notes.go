@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"log"
+	"strings"
+	"time"
+)
+
+const statusNoteMarker = "<!-- mergemate-status -->"
+
+type publishedStatus struct {
+	noteId int
+	status string
+	action string
+	reason string
+}
+
+func (e *Engine) findStatusNote(notes []gitlab.MergeRequestNote) int {
+	for _, note := range notes {
+		if note.Author.Username == e.userName && strings.Contains(note.Body, statusNoteMarker) {
+			return note.Id
+		}
+	}
+	return 0
+}
+
+// publishStatus creates or updates status note of merge request, outcomes without status, i.e. merge which gitlab didn't
+// finish or pipeline which was canceled, are published with their reason. Only evaluation which found nothing is skipped.
+func (e *Engine) publishStatus(mergeRequestIid int, notes []gitlab.MergeRequestNote, current report) {
+	if current.status == "" && current.action == "" && current.reason == "" {
+		return
+	}
+	e.mutex.Lock()
+	previous, exists := e.published[mergeRequestIid]
+	e.mutex.Unlock()
+	if !exists {
+		previous.noteId = e.findStatusNote(notes)
+	}
+	next := publishedStatus{
+		noteId: previous.noteId,
+		status: current.status,
+		action: current.action,
+		reason: current.reason,
+	}
+	if next.action == "" {
+		next.action = previous.action
+	}
+	if exists && next == previous {
+		return
+	}
+
+	body := statusNoteBody(next)
+	var note *gitlab.MergeRequestNote
+	var err error
+	if next.noteId == 0 {
+		note, err = e.client.CreateMergeRequestNote(mergeRequestIid, body)
+	} else {
+		note, err = e.client.UpdateMergeRequestNote(mergeRequestIid, next.noteId, body)
+	}
+	if err != nil {
+		log.Printf("Error when publishing status of merge request {id = %v}: %v", mergeRequestIid, err)
+		// status note is looked up again next time, it may have been deleted
+		e.mutex.Lock()
+		delete(e.published, mergeRequestIid)
+		e.mutex.Unlock()
+		return
+	}
+	if note.Id == 0 {
+		log.Printf("Gitlab returned status note of merge request {id = %v} without id, it will be looked up again", mergeRequestIid)
+		return
+	}
+	next.noteId = note.Id
+
+	e.mutex.Lock()
+	e.published[mergeRequestIid] = next
+	e.mutex.Unlock()
+}
+
+func statusNoteBody(status publishedStatus) string {
+	state := status.status
+	if state == "" {
+		state = "-"
+	}
+	action := status.action
+	if action == "" {
+		action = "-"
+	}
+	reason := status.reason
+	if reason == "" {
+		reason = "-"
+	}
+	return fmt.Sprintf("**mergemate status**\n\n| | |\n|---|---|\n| State | %s |\n| Last action | %s |\n| Reason | %s |\n| Updated | %s |\n\n%s",
+		state, action, reason, time.Now().Format(time.RFC1123), statusNoteMarker)
+}
@@ -0,0 +1,68 @@
+package gitlab
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const UserEndpoint = "/api/v4/user"
+const ProjectsEndpoint = "/api/v4/projects"
+
+// maxMemberProjects limits number of projects downloaded when looking for projects of the user.
+const maxMemberProjects = 1000
+
+type User struct {
+	Id          int    `json:"id"`
+	Username    string `json:"username"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	CommitEmail string `json:"commit_email"`
+}
+
+type Project struct {
+	Id                int    `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	DefaultBranch     string `json:"default_branch"`
+}
+
+// CurrentUser returns owner of the api token.
+func (client *ApiClient) CurrentUser() (*User, error) {
+	var user User
+	response, err := client.resty.R().
+		SetResult(&user).
+		Get(UserEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("token was rejected by gitlab: %v", response.Status())
+	}
+	return &user, nil
+}
+
+// MemberProjects lists projects the user is a member of, recently active projects first.
+func (client *ApiClient) MemberProjects() ([]Project, error) {
+	var result []Project
+	for page := 1; len(result) < maxMemberProjects; page++ {
+		var projects []Project
+		response, err := client.resty.R().
+			SetQueryParam("membership", "true").
+			SetQueryParam("simple", "true").
+			SetQueryParam("order_by", "last_activity_at").
+			SetQueryParam("per_page", "100").
+			SetQueryParam("page", strconv.Itoa(page)).
+			SetResult(&projects).
+			Get(ProjectsEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		if response.IsError() {
+			return nil, fmt.Errorf("unexpected response status %v", response.Status())
+		}
+		result = append(result, projects...)
+		if response.Header().Get("X-Next-Page") == "" {
+			break
+		}
+	}
+	return result, nil
+}
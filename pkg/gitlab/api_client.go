@@ -2,15 +2,19 @@ package gitlab
 
 import (
 	"errors"
+	"fmt"
 	"github.com/go-resty/resty/v2"
-	"log"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const projectIdParam = "projectId"
+const groupIdParam = "groupId"
 const branchIdParam = "branchId"
+const noteIdParam = "noteId"
 const sourceBranchParam = "source_branch"
 const targetBranchParam = "target_branch"
 const removeSourceBranchParam = "remove_source_branch"
@@ -24,10 +28,12 @@ const skipCi = "skip_ci"
 const includeDivergedCommits = "include_diverged_commits_count"
 const includeRebaseInProgress = "include_rebase_in_progress"
 const MergeRequestsEndpoint = "/api/v4/projects/{" + projectIdParam + "}/merge_requests"
+const GroupMergeRequestsEndpoint = "/api/v4/groups/{" + groupIdParam + "}/merge_requests"
 const MergeRequestsMergeEndpoint = "/api/v4/projects/{" + projectIdParam + "}/merge_requests/{" + mergeRequestIdParam + "}/merge"
 const MergeRequestsDetailsEndpoint = "/api/v4/projects/{" + projectIdParam + "}/merge_requests/{" + mergeRequestIdParam + "}"
 const MergeRequestsRebaseEndpoint = "/api/v4/projects/{" + projectIdParam + "}/merge_requests/{" + mergeRequestIdParam + "}/rebase"
 const MergeRequestsEventsEndpoint = "/api/v4/projects/{" + projectIdParam + "}/merge_requests/{" + mergeRequestIdParam + "}/notes"
+const MergeRequestsNoteEndpoint = "/api/v4/projects/{" + projectIdParam + "}/merge_requests/{" + mergeRequestIdParam + "}/notes/{" + noteIdParam + "}"
 const MergeRequestsPipelinesEndpoint = "/api/v4/projects/{" + projectIdParam + "}/merge_requests/{" + mergeRequestIdParam + "}/pipelines"
 const BranchesEndpoint = "/api/v4/projects/{" + projectIdParam + "}/repository/branches"
 const DeleteBranchEndpoint = "/api/v4/projects/{" + projectIdParam + "}/repository/branches/{" + branchIdParam + "}"
@@ -37,33 +43,90 @@ type ApiClient struct {
 	projectName string
 	userName    string
 	apiToken    string
+	requests    requestLog
+	// shared counts requests of all clients sharing a request budget with this one
+	shared  *SharedRequests
+	cache   *responseCache
+	breaker breaker
+	backend string
+	// groupName is set for clients which list merge requests of all projects in a group
+	groupName string
+	// prefetched is filled by Prefetch when graphql backend is used
+	prefetched    map[int]*prefetchedMergeRequest
+	prefetchMutex sync.Mutex
 }
+
+type requestLog struct {
+	mutex sync.Mutex
+	times []time.Time
+	// total is a number of all requests sent by the client
+	total int
+}
+
+// SharedRequests counts requests sent by several clients, so they can share a request budget.
+type SharedRequests struct {
+	log requestLog
+}
+
+func NewSharedRequests() *SharedRequests {
+	return &SharedRequests{}
+}
+
 type MergeRequestDetails struct {
-	Id                        int    `json:"id"`
-	Iid                       int    `json:"iid"`
-	Title                     string `json:"title"`
-	State                     string `json:"state"`
-	TargetBranch              string `json:"target_branch"`
-	SourceBranch              string `json:"source_branch"`
-	MergeWhenPipelineSucceeds bool   `json:"merge_when_pipeline_succeeds"`
-	MergeStatus               string `json:"merge_status"`
-	DetailedMergeStatus       string `json:"detailed_merge_status"`
-	HasConflicts              bool   `json:"has_conflicts"`
-	ShouldRemoveSourceBranch  bool   `json:"should_remove_source_branch"`
-	CommitsBehind             int    `json:"diverged_commits_count"`
-	Sha                       string `json:"sha"`
-	RebaseInProgress          bool   `json:"rebase_in_progress"`
-	RebaseError               string `json:"merge_error"`
+	Id                        int        `json:"id"`
+	Iid                       int        `json:"iid"`
+	ProjectId                 int        `json:"project_id"`
+	Title                     string     `json:"title"`
+	WebUrl                    string     `json:"web_url"`
+	State                     string     `json:"state"`
+	TargetBranch              string     `json:"target_branch"`
+	SourceBranch              string     `json:"source_branch"`
+	MergeWhenPipelineSucceeds bool       `json:"merge_when_pipeline_succeeds"`
+	MergeStatus               string     `json:"merge_status"`
+	DetailedMergeStatus       string     `json:"detailed_merge_status"`
+	HasConflicts              bool       `json:"has_conflicts"`
+	ShouldRemoveSourceBranch  bool       `json:"should_remove_source_branch"`
+	CommitsBehind             int        `json:"diverged_commits_count"`
+	Sha                       string     `json:"sha"`
+	RebaseInProgress          bool       `json:"rebase_in_progress"`
+	RebaseError               string     `json:"merge_error"`
+	CreatedAt                 time.Time  `json:"created_at"`
+	UpdatedAt                 time.Time  `json:"updated_at"`
+	References                References `json:"references"`
+}
+
+type References struct {
+	Full string `json:"full"`
+}
+
+// ProjectPath returns path with namespace of the project merge request belongs to, project id is returned by gitlab
+// versions which don't provide references.
+func (mergeRequest MergeRequestDetails) ProjectPath() string {
+	path, _, _ := strings.Cut(mergeRequest.References.Full, "!")
+	if path == "" {
+		return strconv.Itoa(mergeRequest.ProjectId)
+	}
+	return path
 }
 
 type MergeRequestNote struct {
-	MergeRequestIid int    `json:"noteable_iid"`
-	Body            string `json:"body"`
+	Id              int        `json:"id"`
+	MergeRequestIid int        `json:"noteable_iid"`
+	Body            string     `json:"body"`
+	Author          NoteAuthor `json:"author"`
+	System          bool       `json:"system"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+type NoteAuthor struct {
+	Username string `json:"username"`
 }
 
 type CommitDetails struct {
 	AuthoredDate time.Time `json:"authored_date"`
 	Message      string    `json:"message"`
+	AuthorName   string    `json:"author_name"`
+	AuthorEmail  string    `json:"author_email"`
 }
 type Branch struct {
 	Name    string        `json:"name"`
@@ -82,27 +145,21 @@ func (client *ApiClient) MergedMergeRequests() ([]MergeRequestDetails, error) {
 }
 
 func (client *ApiClient) ListMergeRequests(state string) ([]MergeRequestDetails, error) {
-	var mergeRequests []MergeRequestDetails
-	_, err := client.resty.R().
-		SetResult(&mergeRequests).
-		SetQueryParam("author_username", client.userName).
-		SetQueryParam("state", state).
-		SetQueryParam("per_page", "100").
-		SetPathParam(projectIdParam, client.projectName).
-		Get(MergeRequestsEndpoint)
-	if err != nil {
-		return nil, err
-	}
-	return mergeRequests, nil
+	return client.syncMergeRequests(state)
 }
 
 func (client *ApiClient) ListMergeRequestNotes(mergeRequestIid int) ([]MergeRequestNote, error) {
+	if notes := client.prefetchedNotes(mergeRequestIid); notes != nil {
+		return notes, nil
+	}
 	var notes []MergeRequestNote
-	_, err := client.resty.R().
-		SetResult(&notes).
+	request := client.resty.R().
 		SetPathParam(projectIdParam, client.projectName).
 		SetPathParam(mergeRequestIdParam, strconv.Itoa(mergeRequestIid)).
-		Get(MergeRequestsEventsEndpoint)
+		SetQueryParam("order_by", "created_at").
+		SetQueryParam("sort", "asc").
+		SetQueryParam("per_page", "100")
+	err := client.getCached(request, MergeRequestsEventsEndpoint, &notes)
 
 	if err != nil {
 		return nil, err
@@ -111,9 +168,9 @@ func (client *ApiClient) ListMergeRequestNotes(mergeRequestIid int) ([]MergeRequ
 	return notes, nil
 }
 
-func (client *ApiClient) CreateMergeRequestNote(mergeRequestIid int, noteBody string) error {
+func (client *ApiClient) CreateMergeRequestNote(mergeRequestIid int, noteBody string) (*MergeRequestNote, error) {
 	var note MergeRequestNote
-	_, err := client.resty.R().
+	response, err := client.resty.R().
 		SetResult(&note).
 		SetPathParam(projectIdParam, client.projectName).
 		SetPathParam(mergeRequestIdParam, strconv.Itoa(mergeRequestIid)).
@@ -121,10 +178,33 @@ func (client *ApiClient) CreateMergeRequestNote(mergeRequestIid int, noteBody st
 		Post(MergeRequestsEventsEndpoint)
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("unexpected response status %v", response.Status())
 	}
 
-	return nil
+	return &note, nil
+}
+
+func (client *ApiClient) UpdateMergeRequestNote(mergeRequestIid int, noteId int, noteBody string) (*MergeRequestNote, error) {
+	var note MergeRequestNote
+	response, err := client.resty.R().
+		SetResult(&note).
+		SetPathParam(projectIdParam, client.projectName).
+		SetPathParam(mergeRequestIdParam, strconv.Itoa(mergeRequestIid)).
+		SetPathParam(noteIdParam, strconv.Itoa(noteId)).
+		SetQueryParam("body", noteBody).
+		Put(MergeRequestsNoteEndpoint)
+
+	if err != nil {
+		return nil, err
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("unexpected response status %v", response.Status())
+	}
+
+	return &note, nil
 }
 
 func (client *ApiClient) listBranches(namePatterns []string) ([]Branch, error) {
@@ -132,12 +212,11 @@ func (client *ApiClient) listBranches(namePatterns []string) ([]Branch, error) {
 
 	for _, pattern := range namePatterns {
 		var branches []Branch
-		_, err := client.resty.R().
-			SetResult(&branches).
+		request := client.resty.R().
 			SetPathParam(projectIdParam, client.projectName).
 			SetQueryParam("search", "^"+pattern).
-			SetQueryParam("per_page", "100").
-			Get(BranchesEndpoint)
+			SetQueryParam("per_page", "100")
+		err := client.getCached(request, BranchesEndpoint, &branches)
 		if err != nil {
 			return nil, err
 		}
@@ -148,18 +227,18 @@ func (client *ApiClient) listBranches(namePatterns []string) ([]Branch, error) {
 	return result, nil
 }
 
-func (client *ApiClient) FetchBranchesWithPattern(patterns []string) []Branch {
+func (client *ApiClient) FetchBranchesWithPattern(patterns []string) ([]Branch, error) {
 	branches, err := client.listBranches(patterns)
 
 	if err != nil {
-		log.Printf("Error when fetching branches list %v", err)
+		return nil, err
 	}
 
 	sort.SliceStable(branches, func(i, j int) bool {
 		return branches[i].Commit.AuthoredDate.Unix() > branches[j].Commit.AuthoredDate.Unix()
 	})
 
-	return branches
+	return branches, nil
 }
 
 func (client *ApiClient) DeleteBranch(branchName string) error {
@@ -193,6 +272,9 @@ func (client *ApiClient) CreateMergeRequest(sourceBranch string, targetBranch st
 	if resp.StatusCode() == 409 {
 		return nil, MergeRequestAlreadyExists
 	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("merge request creation failed with status %v", resp.Status())
+	}
 
 	return &result, nil
 }
@@ -215,14 +297,16 @@ func (client *ApiClient) MergeMergeRequest(mergeRequestIid int, currentSha strin
 }
 
 func (client *ApiClient) GetMergeRequestDetails(mergeRequestIid int) (*MergeRequestDetails, error) {
+	if mergeRequest := client.prefetchedDetails(mergeRequestIid); mergeRequest != nil {
+		return mergeRequest, nil
+	}
 	var mergeRequest MergeRequestDetails
-	_, err := client.resty.R().
+	request := client.resty.R().
 		SetPathParam(projectIdParam, client.projectName).
 		SetPathParam(mergeRequestIdParam, strconv.Itoa(mergeRequestIid)).
 		SetQueryParam(includeDivergedCommits, "true").
-		SetQueryParam(includeRebaseInProgress, "true").
-		SetResult(&mergeRequest).
-		Get(MergeRequestsDetailsEndpoint)
+		SetQueryParam(includeRebaseInProgress, "true")
+	err := client.getCached(request, MergeRequestsDetailsEndpoint, &mergeRequest)
 
 	if err != nil {
 		return nil, err
@@ -232,13 +316,19 @@ func (client *ApiClient) GetMergeRequestDetails(mergeRequestIid int) (*MergeRequ
 
 func (client *ApiClient) RebaseMergeRequest(mergeRequestIid int, shouldSkipCi bool) error {
 	var mergeRequest MergeRequestDetails
-	_, err := client.resty.R().
+	response, err := client.resty.R().
 		SetPathParam(projectIdParam, client.projectName).
 		SetPathParam(mergeRequestIdParam, strconv.Itoa(mergeRequestIid)).
 		SetQueryParam(skipCi, strconv.FormatBool(shouldSkipCi)).
 		SetResult(&mergeRequest).
 		Put(MergeRequestsRebaseEndpoint)
-	return err
+	if err != nil {
+		return err
+	}
+	if response.IsError() {
+		return fmt.Errorf("unexpected response status %v", response.Status())
+	}
+	return nil
 }
 
 type MergeRequestPipeline struct {
@@ -251,14 +341,16 @@ type MergeRequestPipeline struct {
 }
 
 func (client *ApiClient) GetMergeRequestPipelines(mergeRequestIid int) ([]MergeRequestPipeline, error) {
+	if pipelines := client.prefetchedPipelines(mergeRequestIid); pipelines != nil {
+		return pipelines, nil
+	}
 	var pipelines []MergeRequestPipeline
-	_, err := client.resty.R().
-		SetResult(&pipelines).
+	request := client.resty.R().
 		SetPathParam(projectIdParam, client.projectName).
 		SetPathParam(mergeRequestIdParam, strconv.Itoa(mergeRequestIid)).
 		SetQueryParam("order_by", "id").
-		SetQueryParam("sort", "asc").
-		Get(MergeRequestsPipelinesEndpoint)
+		SetQueryParam("sort", "asc")
+	err := client.getCached(request, MergeRequestsPipelinesEndpoint, &pipelines)
 
 	if err != nil {
 		return nil, err
@@ -276,13 +368,88 @@ func New(gitlabUrl string, projectName string, userName string, apiToken string)
 		projectName: projectName,
 		userName:    userName,
 		apiToken:    apiToken,
+		cache:       newResponseCache(),
+		backend:     BackendRest,
 	}
+	client.resty.OnBeforeRequest(func(_ *resty.Client, _ *resty.Request) error {
+		now := time.Now()
+		err := client.breaker.allow(now)
+		if err != nil {
+			return err
+		}
+		client.requests.record(now)
+		if client.shared != nil {
+			client.shared.log.record(now)
+		}
+		return nil
+	})
+	client.resty.OnAfterResponse(client.breaker.onResponse)
+	client.resty.OnError(client.breaker.onError)
 	return client
 }
+
+// NewGroup creates client listing merge requests of all projects in a group, only ListMergeRequests and its variants
+// can be used with it, merge requests have to be managed with clients of their projects.
+func NewGroup(gitlabUrl string, groupName string, userName string, apiToken string) *ApiClient {
+	client := New(gitlabUrl, "", userName, apiToken)
+	client.groupName = groupName
+	return client
+}
+
+func (client *ApiClient) ProjectName() string {
+	return client.projectName
+}
+
+// ShareRequests counts requests of the client together with other clients using the same shared requests.
+func (client *ApiClient) ShareRequests(shared *SharedRequests) {
+	client.shared = shared
+}
+
+// RequestsInLastMinute returns number of requests sent in the last minute by the client, or by all clients sharing
+// requests with it.
+func (client *ApiClient) RequestsInLastMinute() int {
+	if client.shared != nil {
+		return client.shared.log.countSince(time.Now().Add(-time.Minute))
+	}
+	return client.requests.countSince(time.Now().Add(-time.Minute))
+}
+
+// RequestsSent returns number of all requests sent by the client, including the ones rejected by gitlab.
+func (client *ApiClient) RequestsSent() int {
+	client.requests.mutex.Lock()
+	defer client.requests.mutex.Unlock()
+	return client.requests.total
+}
+
+func (log *requestLog) record(at time.Time) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	log.times = append(log.times, at)
+	log.total++
+	// only requests from the last minute are counted, older ones can be forgotten
+	cutoff := at.Add(-time.Minute)
+	firstRecent := 0
+	for firstRecent < len(log.times) && log.times[firstRecent].Before(cutoff) {
+		firstRecent++
+	}
+	log.times = log.times[firstRecent:]
+}
+
+func (log *requestLog) countSince(since time.Time) int {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	count := 0
+	for _, at := range log.times {
+		if !at.Before(since) {
+			count++
+		}
+	}
+	return count
+}
 func createClient(gitlabUrl string, apiToken string) *resty.Client {
 	client := resty.New()
 	client.SetBaseURL(gitlabUrl)
 	client.SetHeader(tokenHeader, apiToken)
-	client.SetTimeout(time.Second * 10)
+	client.SetTimeout(defaultTimeout)
 	return client
 }
@@ -0,0 +1,135 @@
+package gitlab
+
+import (
+	"errors"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Health string
+
+const (
+	HealthConnected   Health = "connected"
+	HealthDegraded    Health = "degraded"
+	HealthOffline     Health = "offline"
+	HealthRateLimited Health = "rate-limited"
+)
+
+// failureThreshold is the number of consecutive failed requests after which circuit opens.
+const failureThreshold = 5
+const minBackoff = time.Second * 5
+const maxBackoff = time.Minute * 5
+const defaultRateLimitWait = time.Minute
+
+var CircuitOpen = errors.New("gitlab is not reachable, request was not sent")
+
+// breaker stops sending requests when gitlab keeps failing, once backoff passes a single probe request is let through.
+type breaker struct {
+	mutex     sync.Mutex
+	health    Health
+	failures  int
+	backoff   time.Duration
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) allow(now time.Time) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return CircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *breaker) succeeded() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.health = HealthConnected
+	b.failures = 0
+	b.backoff = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+func (b *breaker) failed(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	if b.failures < failureThreshold && !b.probing {
+		b.health = HealthDegraded
+		return
+	}
+	b.backoff *= 2
+	if b.backoff < minBackoff {
+		b.backoff = minBackoff
+	}
+	if b.backoff > maxBackoff {
+		b.backoff = maxBackoff
+	}
+	b.health = HealthOffline
+	b.openUntil = now.Add(b.backoff)
+	b.probing = false
+}
+
+func (b *breaker) rateLimited(now time.Time, wait time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.health = HealthRateLimited
+	b.openUntil = now.Add(wait)
+	b.probing = false
+}
+
+func (b *breaker) state(now time.Time) (Health, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.health == "" {
+		return HealthConnected, 0
+	}
+	if now.Before(b.openUntil) {
+		return b.health, b.openUntil.Sub(now)
+	}
+	return b.health, 0
+}
+
+func (b *breaker) onResponse(_ *resty.Client, response *resty.Response) error {
+	now := time.Now()
+	switch {
+	case response.StatusCode() == http.StatusTooManyRequests:
+		b.rateLimited(now, retryAfter(response))
+	case response.StatusCode() >= http.StatusInternalServerError:
+		b.failed(now)
+	default:
+		b.succeeded()
+	}
+	return nil
+}
+
+func (b *breaker) onError(_ *resty.Request, err error) {
+	var responseError *resty.ResponseError
+	if errors.Is(err, CircuitOpen) || errors.As(err, &responseError) && responseError.Response.RawResponse != nil {
+		// received responses were already handled by onResponse
+		return
+	}
+	b.failed(time.Now())
+}
+
+func retryAfter(response *resty.Response) time.Duration {
+	seconds, err := strconv.Atoi(response.Header().Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return defaultRateLimitWait
+	}
+	return time.Second * time.Duration(seconds)
+}
+
+// Health returns state of connection to gitlab and time left until next request will be sent, when requests are suspended.
+func (client *ApiClient) Health() (Health, time.Duration) {
+	return client.breaker.state(time.Now())
+}
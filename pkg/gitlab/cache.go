@@ -0,0 +1,162 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncOverlap is subtracted from last sync time to not miss merge requests updated while previous sync was in progress.
+const syncOverlap = time.Minute
+
+// fullSyncInterval forces download of the whole list from time to time, so that deleted merge requests disappear.
+const fullSyncInterval = time.Minute * 30
+
+type cachedResponse struct {
+	etag string
+	body []byte
+}
+
+type syncedList struct {
+	syncedAt   time.Time
+	fullSyncAt time.Time
+	// mergeRequests are keyed by id, iids are not unique in lists of a group
+	mergeRequests map[int]MergeRequestDetails
+}
+
+type cachedNotes struct {
+	updatedAt time.Time
+	notes     []MergeRequestNote
+}
+
+type responseCache struct {
+	mutex     sync.Mutex
+	responses map[string]cachedResponse
+	lists     map[string]*syncedList
+	notes     map[int]cachedNotes
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{
+		responses: make(map[string]cachedResponse),
+		lists:     make(map[string]*syncedList),
+		notes:     make(map[int]cachedNotes),
+	}
+}
+
+func cacheKey(request *resty.Request, endpoint string) string {
+	key := endpoint
+	var params []string
+	for name, value := range request.PathParams {
+		params = append(params, name+"="+value)
+	}
+	sort.Strings(params)
+	return key + "|" + strings.Join(params, "&") + "|" + request.QueryParam.Encode()
+}
+
+// getCached sends GET request with If-None-Match header when response for the same request was seen before,
+// body of the cached response is used when gitlab answers with 304 Not Modified.
+func (client *ApiClient) getCached(request *resty.Request, endpoint string, result interface{}) error {
+	key := cacheKey(request, endpoint)
+	client.cache.mutex.Lock()
+	cached, exists := client.cache.responses[key]
+	client.cache.mutex.Unlock()
+	if exists {
+		request.SetHeader("If-None-Match", cached.etag)
+	}
+	response, err := request.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	body := response.Body()
+	switch {
+	case response.StatusCode() == http.StatusNotModified && exists:
+		body = cached.body
+	case response.IsSuccess():
+		if etag := response.Header().Get("ETag"); etag != "" {
+			client.cache.mutex.Lock()
+			client.cache.responses[key] = cachedResponse{etag: etag, body: body}
+			client.cache.mutex.Unlock()
+		}
+	default:
+		return fmt.Errorf("unexpected response status %v", response.Status())
+	}
+	return json.Unmarshal(body, result)
+}
+
+// syncMergeRequests downloads merge requests updated since the last sync and merges them into the list of merge requests in a given state.
+func (client *ApiClient) syncMergeRequests(state string) ([]MergeRequestDetails, error) {
+	client.cache.mutex.Lock()
+	list, exists := client.cache.lists[state]
+	client.cache.mutex.Unlock()
+	startedAt := time.Now()
+	fullSync := !exists || startedAt.Sub(list.fullSyncAt) > fullSyncInterval
+
+	request := client.resty.R().
+		SetQueryParam("author_username", client.userName).
+		SetQueryParam("per_page", "100")
+	endpoint := MergeRequestsEndpoint
+	if client.groupName != "" {
+		endpoint = GroupMergeRequestsEndpoint
+		request.SetPathParam(groupIdParam, client.groupName)
+	} else {
+		request.SetPathParam(projectIdParam, client.projectName)
+	}
+	if fullSync {
+		request.SetQueryParam("state", state)
+	} else {
+		// no state filter, merge requests which left the state have to be removed from the list
+		request.SetQueryParam("updated_after", list.syncedAt.Add(-syncOverlap).UTC().Format(time.RFC3339))
+	}
+	var mergeRequests []MergeRequestDetails
+	err := client.getCached(request, endpoint, &mergeRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	client.cache.mutex.Lock()
+	defer client.cache.mutex.Unlock()
+	if fullSync {
+		list = &syncedList{fullSyncAt: startedAt, mergeRequests: make(map[int]MergeRequestDetails)}
+		client.cache.lists[state] = list
+	}
+	list.syncedAt = startedAt
+	for _, mergeRequest := range mergeRequests {
+		if mergeRequest.State == state {
+			list.mergeRequests[mergeRequest.Id] = mergeRequest
+		} else {
+			delete(list.mergeRequests, mergeRequest.Id)
+		}
+	}
+	result := make([]MergeRequestDetails, 0, len(list.mergeRequests))
+	for _, mergeRequest := range list.mergeRequests {
+		result = append(result, mergeRequest)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// CachedMergeRequestNotes lists notes of merge request, they are downloaded again only when merge request was updated.
+func (client *ApiClient) CachedMergeRequestNotes(mergeRequest MergeRequestDetails) ([]MergeRequestNote, error) {
+	client.cache.mutex.Lock()
+	cached, exists := client.cache.notes[mergeRequest.Iid]
+	client.cache.mutex.Unlock()
+	if exists && !mergeRequest.UpdatedAt.IsZero() && cached.updatedAt.Equal(mergeRequest.UpdatedAt) {
+		return cached.notes, nil
+	}
+	notes, err := client.ListMergeRequestNotes(mergeRequest.Iid)
+	if err != nil {
+		return nil, err
+	}
+	client.cache.mutex.Lock()
+	client.cache.notes[mergeRequest.Iid] = cachedNotes{updatedAt: mergeRequest.UpdatedAt, notes: notes}
+	client.cache.mutex.Unlock()
+	return notes, nil
+}
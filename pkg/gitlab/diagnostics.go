@@ -0,0 +1,211 @@
+package gitlab
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const VersionEndpoint = "/api/v4/version"
+const TokenEndpoint = "/api/v4/personal_access_tokens/self"
+const ProjectEndpoint = "/api/v4/projects/{" + projectIdParam + "}"
+const GroupEndpoint = "/api/v4/groups/{" + groupIdParam + "}"
+const ProtectedBranchesEndpoint = "/api/v4/projects/{" + projectIdParam + "}/protected_branches"
+
+// Access levels of project members.
+const (
+	NoAccess         = 0
+	GuestAccess      = 10
+	ReporterAccess   = 20
+	DeveloperAccess  = 30
+	MaintainerAccess = 40
+	OwnerAccess      = 50
+)
+
+// Connection describes connection to gitlab, version is empty when token isn't accepted.
+type Connection struct {
+	Version string
+	TLS     *tls.ConnectionState
+}
+
+type TokenInfo struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Active    bool     `json:"active"`
+	Revoked   bool     `json:"revoked"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+type ProjectDetails struct {
+	Id                int                `json:"id"`
+	PathWithNamespace string             `json:"path_with_namespace"`
+	DefaultBranch     string             `json:"default_branch"`
+	MergeMethod       string             `json:"merge_method"`
+	Permissions       ProjectPermissions `json:"permissions"`
+}
+
+type ProjectPermissions struct {
+	ProjectAccess *MemberAccess `json:"project_access"`
+	GroupAccess   *MemberAccess `json:"group_access"`
+}
+
+type MemberAccess struct {
+	AccessLevel int `json:"access_level"`
+}
+
+type ProtectedBranch struct {
+	Name              string         `json:"name"`
+	PushAccessLevels  []BranchAccess `json:"push_access_levels"`
+	MergeAccessLevels []BranchAccess `json:"merge_access_levels"`
+}
+
+// BranchAccess allows members with given access level, given user or members of given group to push or merge to
+// protected branch.
+type BranchAccess struct {
+	AccessLevel int `json:"access_level"`
+	UserId      int `json:"user_id"`
+	GroupId     int `json:"group_id"`
+}
+
+// CheckConnection connects to gitlab, error is returned only when no response is received.
+func (client *ApiClient) CheckConnection() (*Connection, error) {
+	var version struct {
+		Version string `json:"version"`
+	}
+	response, err := client.resty.R().
+		SetResult(&version).
+		Get(VersionEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &Connection{Version: version.Version, TLS: response.RawResponse.TLS}, nil
+}
+
+// TokenInfo describes api token, it's supported by gitlab 15.5 and newer, nil is returned by older versions.
+func (client *ApiClient) TokenInfo() (*TokenInfo, error) {
+	var token TokenInfo
+	response, err := client.resty.R().
+		SetResult(&token).
+		Get(TokenEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode() == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("unexpected response status %v", response.Status())
+	}
+	return &token, nil
+}
+
+func (client *ApiClient) ProjectDetails() (*ProjectDetails, error) {
+	var project ProjectDetails
+	response, err := client.resty.R().
+		SetPathParam(projectIdParam, client.projectName).
+		SetResult(&project).
+		Get(ProjectEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode() == http.StatusNotFound {
+		return nil, fmt.Errorf("project %v doesn't exist or token has no access to it", client.projectName)
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("unexpected response status %v", response.Status())
+	}
+	return &project, nil
+}
+
+// CheckGroup checks that group of the client exists and is visible with the token.
+func (client *ApiClient) CheckGroup() error {
+	response, err := client.resty.R().
+		SetPathParam(groupIdParam, client.groupName).
+		SetQueryParam("with_projects", "false").
+		Get(GroupEndpoint)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("group %v doesn't exist or token has no access to it", client.groupName)
+	}
+	if response.IsError() {
+		return fmt.Errorf("unexpected response status %v", response.Status())
+	}
+	return nil
+}
+
+func (client *ApiClient) ProtectedBranches() ([]ProtectedBranch, error) {
+	var branches []ProtectedBranch
+	response, err := client.resty.R().
+		SetPathParam(projectIdParam, client.projectName).
+		SetQueryParam("per_page", "100").
+		SetResult(&branches).
+		Get(ProtectedBranchesEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("unexpected response status %v", response.Status())
+	}
+	return branches, nil
+}
+
+// AccessLevel returns the highest access level the user has in project, directly or through its group.
+func (project *ProjectDetails) AccessLevel() int {
+	level := NoAccess
+	for _, access := range []*MemberAccess{project.Permissions.ProjectAccess, project.Permissions.GroupAccess} {
+		if access != nil && access.AccessLevel > level {
+			level = access.AccessLevel
+		}
+	}
+	return level
+}
+
+// Matches tells if protection applies to branch, protected branch name can use * wildcard.
+func (branch ProtectedBranch) Matches(name string) bool {
+	pattern := strings.ReplaceAll(regexp.QuoteMeta(branch.Name), `\*`, ".*")
+	matched, _ := regexp.MatchString("^"+pattern+"$", name)
+	return matched
+}
+
+func (branch ProtectedBranch) AllowsPush(accessLevel int, userId int) bool {
+	return allows(branch.PushAccessLevels, accessLevel, userId)
+}
+
+func (branch ProtectedBranch) AllowsMerge(accessLevel int, userId int) bool {
+	return allows(branch.MergeAccessLevels, accessLevel, userId)
+}
+
+func allows(accesses []BranchAccess, accessLevel int, userId int) bool {
+	for _, access := range accesses {
+		if access.UserId != 0 && access.UserId == userId {
+			return true
+		}
+		// membership of groups isn't known, access given to a group is not taken into account
+		if access.UserId == 0 && access.GroupId == 0 && access.AccessLevel > NoAccess && accessLevel >= access.AccessLevel {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessLevelName returns name of member role with given access level.
+func AccessLevelName(level int) string {
+	switch {
+	case level >= OwnerAccess:
+		return "owner"
+	case level >= MaintainerAccess:
+		return "maintainer"
+	case level >= DeveloperAccess:
+		return "developer"
+	case level >= ReporterAccess:
+		return "reporter"
+	case level >= GuestAccess:
+		return "guest"
+	default:
+		return "no"
+	}
+}
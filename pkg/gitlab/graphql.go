@@ -0,0 +1,295 @@
+package gitlab
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BackendRest    = "rest"
+	BackendGraphql = "graphql"
+)
+
+const GraphqlEndpoint = "/api/graphql"
+
+const openedMergeRequestsQuery = `query($project: ID!, $author: String!, $after: String) {
+  project(fullPath: $project) {
+    mergeRequests(state: opened, authorUsername: $author, first: 50, after: $after) {
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        id iid title webUrl state sourceBranch targetBranch createdAt updatedAt
+        diffHeadSha detailedMergeStatus conflicts shouldRemoveSourceBranch
+        divergedFromTargetBranch rebaseInProgress mergeError
+        pipelines(first: 20) {
+          pageInfo { hasNextPage }
+          nodes { id sha ref status createdAt updatedAt }
+        }
+        notes(first: 100) {
+          pageInfo { hasNextPage }
+          nodes { id body system createdAt author { username } }
+        }
+      }
+    }
+  }
+}`
+
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphqlError struct {
+	Message string `json:"message"`
+}
+
+type graphqlPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type graphqlMergeRequests struct {
+	Data struct {
+		Project *struct {
+			MergeRequests struct {
+				PageInfo graphqlPageInfo       `json:"pageInfo"`
+				Nodes    []graphqlMergeRequest `json:"nodes"`
+			} `json:"mergeRequests"`
+		} `json:"project"`
+	} `json:"data"`
+	Errors []graphqlError `json:"errors"`
+}
+
+type graphqlMergeRequest struct {
+	Id                       string    `json:"id"`
+	Iid                      string    `json:"iid"`
+	Title                    string    `json:"title"`
+	WebUrl                   string    `json:"webUrl"`
+	State                    string    `json:"state"`
+	SourceBranch             string    `json:"sourceBranch"`
+	TargetBranch             string    `json:"targetBranch"`
+	CreatedAt                time.Time `json:"createdAt"`
+	UpdatedAt                time.Time `json:"updatedAt"`
+	DiffHeadSha              string    `json:"diffHeadSha"`
+	DetailedMergeStatus      string    `json:"detailedMergeStatus"`
+	Conflicts                bool      `json:"conflicts"`
+	ShouldRemoveSourceBranch bool      `json:"shouldRemoveSourceBranch"`
+	DivergedFromTargetBranch bool      `json:"divergedFromTargetBranch"`
+	RebaseInProgress         bool      `json:"rebaseInProgress"`
+	MergeError               string    `json:"mergeError"`
+	Pipelines                struct {
+		PageInfo graphqlPageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			Id        string    `json:"id"`
+			Sha       string    `json:"sha"`
+			Ref       string    `json:"ref"`
+			Status    string    `json:"status"`
+			CreatedAt time.Time `json:"createdAt"`
+			UpdatedAt time.Time `json:"updatedAt"`
+		} `json:"nodes"`
+	} `json:"pipelines"`
+	Notes struct {
+		PageInfo graphqlPageInfo `json:"pageInfo"`
+		Nodes    []struct {
+			Id        string     `json:"id"`
+			Body      string     `json:"body"`
+			System    bool       `json:"system"`
+			CreatedAt time.Time  `json:"createdAt"`
+			Author    NoteAuthor `json:"author"`
+		} `json:"nodes"`
+	} `json:"notes"`
+}
+
+// prefetchedMergeRequest keeps data fetched with a single graphql query, every part is used once and then fetched again
+// with rest api. Data left unused is dropped by ClearPrefetch, so that it isn't used by later evaluations.
+type prefetchedMergeRequest struct {
+	details   *MergeRequestDetails
+	notes     []MergeRequestNote
+	pipelines []MergeRequestPipeline
+}
+
+func (client *ApiClient) SetBackend(backend string) error {
+	switch backend {
+	case BackendRest, BackendGraphql:
+		client.backend = backend
+		return nil
+	default:
+		return fmt.Errorf("unknown api backend %v", backend)
+	}
+}
+
+// Prefetch downloads details, notes and pipelines of all opened merge requests with graphql api, so that following calls
+// for them don't need separate requests. It does nothing when rest backend is used.
+func (client *ApiClient) Prefetch() error {
+	if client.backend != BackendGraphql {
+		return nil
+	}
+	prefetched := make(map[int]*prefetchedMergeRequest)
+	cursor := ""
+	for {
+		variables := map[string]interface{}{
+			"project": client.projectName,
+			"author":  client.userName,
+		}
+		if cursor != "" {
+			variables["after"] = cursor
+		}
+		var response graphqlMergeRequests
+		resp, err := client.resty.R().
+			SetBody(graphqlRequest{Query: openedMergeRequestsQuery, Variables: variables}).
+			SetResult(&response).
+			Post(GraphqlEndpoint)
+		if err != nil {
+			return err
+		}
+		if resp.IsError() {
+			return fmt.Errorf("graphql query failed with status %v", resp.Status())
+		}
+		if len(response.Errors) > 0 {
+			return errors.New(response.Errors[0].Message)
+		}
+		if response.Data.Project == nil {
+			return fmt.Errorf("project %v not found", client.projectName)
+		}
+		mergeRequests := response.Data.Project.MergeRequests
+		for _, node := range mergeRequests.Nodes {
+			iid, mergeRequest, err := node.prefetched()
+			if err != nil {
+				return err
+			}
+			prefetched[iid] = mergeRequest
+		}
+		if !mergeRequests.PageInfo.HasNextPage {
+			break
+		}
+		cursor = mergeRequests.PageInfo.EndCursor
+	}
+	client.prefetchMutex.Lock()
+	client.prefetched = prefetched
+	client.prefetchMutex.Unlock()
+	return nil
+}
+
+// ClearPrefetch drops data of the last Prefetch, it's called when the evaluation it was fetched for ends.
+func (client *ApiClient) ClearPrefetch() {
+	client.prefetchMutex.Lock()
+	defer client.prefetchMutex.Unlock()
+	client.prefetched = nil
+}
+
+func (node graphqlMergeRequest) prefetched() (int, *prefetchedMergeRequest, error) {
+	iid, err := strconv.Atoi(node.Iid)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, err := globalIdNumber(node.Id)
+	if err != nil {
+		return 0, nil, err
+	}
+	result := &prefetchedMergeRequest{}
+	// graphql only tells if source branch diverged, number of commits behind is fetched with rest api
+	if !node.DivergedFromTargetBranch {
+		result.details = &MergeRequestDetails{
+			Id:                       id,
+			Iid:                      iid,
+			Title:                    node.Title,
+			WebUrl:                   node.WebUrl,
+			State:                    node.State,
+			TargetBranch:             node.TargetBranch,
+			SourceBranch:             node.SourceBranch,
+			DetailedMergeStatus:      strings.ToLower(node.DetailedMergeStatus),
+			HasConflicts:             node.Conflicts,
+			ShouldRemoveSourceBranch: node.ShouldRemoveSourceBranch,
+			Sha:                      node.DiffHeadSha,
+			RebaseInProgress:         node.RebaseInProgress,
+			RebaseError:              node.MergeError,
+			CreatedAt:                node.CreatedAt,
+			UpdatedAt:                node.UpdatedAt,
+		}
+	}
+	if !node.Pipelines.PageInfo.HasNextPage {
+		result.pipelines = make([]MergeRequestPipeline, 0, len(node.Pipelines.Nodes))
+		for _, pipeline := range node.Pipelines.Nodes {
+			id, err := globalIdNumber(pipeline.Id)
+			if err != nil {
+				return 0, nil, err
+			}
+			result.pipelines = append(result.pipelines, MergeRequestPipeline{
+				Id:        id,
+				Sha:       pipeline.Sha,
+				Ref:       pipeline.Ref,
+				Status:    strings.ToLower(pipeline.Status),
+				CreatedAt: pipeline.CreatedAt,
+				UpdatedAt: pipeline.UpdatedAt,
+			})
+		}
+		sort.SliceStable(result.pipelines, func(i, j int) bool {
+			return result.pipelines[i].CreatedAt.Unix() > result.pipelines[j].CreatedAt.Unix()
+		})
+	}
+	if !node.Notes.PageInfo.HasNextPage {
+		result.notes = make([]MergeRequestNote, 0, len(node.Notes.Nodes))
+		for _, note := range node.Notes.Nodes {
+			id, err := globalIdNumber(note.Id)
+			if err != nil {
+				return 0, nil, err
+			}
+			result.notes = append(result.notes, MergeRequestNote{
+				Id:              id,
+				MergeRequestIid: iid,
+				Body:            note.Body,
+				Author:          note.Author,
+				System:          note.System,
+				CreatedAt:       note.CreatedAt,
+			})
+		}
+		sort.SliceStable(result.notes, func(i, j int) bool {
+			return result.notes[i].CreatedAt.Before(result.notes[j].CreatedAt)
+		})
+	}
+	return iid, result, nil
+}
+
+// globalIdNumber extracts database id from graphql global id, i.e, gid://gitlab/Note/123.
+func globalIdNumber(globalId string) (int, error) {
+	return strconv.Atoi(globalId[strings.LastIndex(globalId, "/")+1:])
+}
+
+func (client *ApiClient) prefetchedDetails(mergeRequestIid int) *MergeRequestDetails {
+	client.prefetchMutex.Lock()
+	defer client.prefetchMutex.Unlock()
+	prefetched, exists := client.prefetched[mergeRequestIid]
+	if !exists || prefetched.details == nil {
+		return nil
+	}
+	details := prefetched.details
+	prefetched.details = nil
+	return details
+}
+
+func (client *ApiClient) prefetchedNotes(mergeRequestIid int) []MergeRequestNote {
+	client.prefetchMutex.Lock()
+	defer client.prefetchMutex.Unlock()
+	prefetched, exists := client.prefetched[mergeRequestIid]
+	if !exists {
+		return nil
+	}
+	notes := prefetched.notes
+	prefetched.notes = nil
+	return notes
+}
+
+func (client *ApiClient) prefetchedPipelines(mergeRequestIid int) []MergeRequestPipeline {
+	client.prefetchMutex.Lock()
+	defer client.prefetchMutex.Unlock()
+	prefetched, exists := client.prefetched[mergeRequestIid]
+	if !exists {
+		return nil
+	}
+	pipelines := prefetched.pipelines
+	prefetched.pipelines = nil
+	return pipelines
+}
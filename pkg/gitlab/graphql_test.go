@@ -0,0 +1,306 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// graphqlStandIn answers graphql queries with prepared pages and rest requests for merge request details, notes and
+// pipelines, every request is recorded.
+type graphqlStandIn struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	requests []string
+	// pages are returned for consecutive cursors, empty cursor gets the first page
+	pages []string
+	// failure is returned instead of pages when set
+	failure string
+	status  int
+}
+
+func newGraphqlStandIn(t *testing.T, pages ...string) *graphqlStandIn {
+	standIn := &graphqlStandIn{pages: pages, status: http.StatusOK}
+	standIn.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		if request.URL.Path == GraphqlEndpoint {
+			var query graphqlRequest
+			err := json.NewDecoder(request.Body).Decode(&query)
+			if err != nil {
+				t.Errorf("invalid graphql request: %v", err)
+			}
+			if query.Variables["project"] != "group/app" || query.Variables["author"] != "me" {
+				t.Errorf("unexpected variables %v", query.Variables)
+			}
+			after, _ := query.Variables["after"].(string)
+			standIn.record(fmt.Sprintf("graphql after=%v", after))
+			if standIn.status != http.StatusOK {
+				writer.WriteHeader(standIn.status)
+				return
+			}
+			if standIn.failure != "" {
+				fmt.Fprint(writer, standIn.failure)
+				return
+			}
+			page := 0
+			if after != "" {
+				fmt.Sscanf(after, "cursor-%d", &page)
+			}
+			fmt.Fprint(writer, standIn.pages[page])
+			return
+		}
+		standIn.record("rest " + request.URL.EscapedPath())
+		switch {
+		case strings.HasSuffix(request.URL.Path, "/notes"):
+			fmt.Fprint(writer, `[{"id":900,"body":"rest note"}]`)
+		case strings.HasSuffix(request.URL.Path, "/pipelines"):
+			fmt.Fprint(writer, `[{"id":800,"status":"running"}]`)
+		default:
+			fmt.Fprint(writer, `{"iid":1,"title":"from rest","diverged_commits_count":2}`)
+		}
+	}))
+	t.Cleanup(standIn.Close)
+	return standIn
+}
+
+func (s *graphqlStandIn) record(request string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, request)
+}
+
+func (s *graphqlStandIn) recorded() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func graphqlPage(hasNextPage bool, cursor string, nodes ...string) string {
+	return fmt.Sprintf(`{"data":{"project":{"mergeRequests":{"pageInfo":{"hasNextPage":%v,"endCursor":"%v"},"nodes":[%v]}}}}`,
+		hasNextPage, cursor, strings.Join(nodes, ","))
+}
+
+func graphqlNode(iid int, diverged bool) string {
+	return fmt.Sprintf(`{
+		"id":"gid://gitlab/MergeRequest/%[1]d00","iid":"%[1]d","title":"Merge request %[1]d","webUrl":"https://gitlab/%[1]d",
+		"state":"opened","sourceBranch":"feature-%[1]d","targetBranch":"main",
+		"createdAt":"2024-01-01T10:00:00Z","updatedAt":"2024-01-02T10:00:00Z",
+		"diffHeadSha":"sha%[1]d","detailedMergeStatus":"CI_STILL_RUNNING","conflicts":false,"shouldRemoveSourceBranch":true,
+		"divergedFromTargetBranch":%[2]v,"rebaseInProgress":false,"mergeError":null,
+		"pipelines":{"pageInfo":{"hasNextPage":false},"nodes":[
+			{"id":"gid://gitlab/Ci::Pipeline/11","sha":"old","ref":"feature","status":"FAILED","createdAt":"2024-01-01T11:00:00Z","updatedAt":"2024-01-01T11:30:00Z"},
+			{"id":"gid://gitlab/Ci::Pipeline/12","sha":"sha%[1]d","ref":"feature","status":"SUCCESS","createdAt":"2024-01-02T11:00:00Z","updatedAt":"2024-01-02T11:30:00Z"}
+		]},
+		"notes":{"pageInfo":{"hasNextPage":false},"nodes":[
+			{"id":"gid://gitlab/Note/22","body":"second","system":true,"createdAt":"2024-01-02T12:00:00Z","author":{"username":"bot"}},
+			{"id":"gid://gitlab/Note/21","body":"first","system":false,"createdAt":"2024-01-01T12:00:00Z","author":{"username":"me"}}
+		]}
+	}`, iid, diverged)
+}
+
+func newGraphqlClient(standIn *graphqlStandIn) *ApiClient {
+	client := New(standIn.URL, "group/app", "me", "token")
+	_ = client.SetBackend(BackendGraphql)
+	return client
+}
+
+func TestPrefetchFetchesAllPagesInBatches(t *testing.T) {
+	standIn := newGraphqlStandIn(t,
+		graphqlPage(true, "cursor-1", graphqlNode(1, false), graphqlNode(2, false)),
+		graphqlPage(false, "", graphqlNode(3, false)))
+	client := newGraphqlClient(standIn)
+
+	err := client.Prefetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := standIn.recorded()
+	if strings.Join(requests, ";") != "graphql after=;graphql after=cursor-1" {
+		t.Fatalf("expected one query per page, got %v", requests)
+	}
+	for _, iid := range []int{1, 2, 3} {
+		_, err = client.GetMergeRequestDetails(iid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.ListMergeRequestNotes(iid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.GetMergeRequestPipelines(iid)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests := standIn.recorded(); len(requests) > 0 {
+		t.Errorf("prefetched data should be used without requests, got %v", requests)
+	}
+}
+
+func TestPrefetchMapping(t *testing.T) {
+	standIn := newGraphqlStandIn(t, graphqlPage(false, "", graphqlNode(4, false)))
+	client := newGraphqlClient(standIn)
+	err := client.Prefetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	details, err := client.GetMergeRequestDetails(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := MergeRequestDetails{
+		Id:                       400,
+		Iid:                      4,
+		Title:                    "Merge request 4",
+		WebUrl:                   "https://gitlab/4",
+		State:                    "opened",
+		SourceBranch:             "feature-4",
+		TargetBranch:             "main",
+		DetailedMergeStatus:      "ci_still_running",
+		ShouldRemoveSourceBranch: true,
+		Sha:                      "sha4",
+		CreatedAt:                time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		UpdatedAt:                time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+	}
+	if *details != expected {
+		t.Errorf("expected details %+v, got %+v", expected, *details)
+	}
+
+	pipelines, err := client.GetMergeRequestPipelines(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipelines) != 2 || pipelines[0].Id != 12 || pipelines[0].Status != "success" || pipelines[1].Id != 11 || pipelines[1].Status != "failed" {
+		t.Errorf("expected pipelines with lower case statuses, the latest first, got %+v", pipelines)
+	}
+	if !IsAutomaticMergeAllowed(pipelines) {
+		t.Error("the latest pipeline succeeded, merge should be allowed")
+	}
+
+	notes, err := client.ListMergeRequestNotes(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Id != 21 || notes[0].Author.Username != "me" || notes[1].Id != 22 || !notes[1].System || notes[0].MergeRequestIid != 4 {
+		t.Errorf("expected notes in order they were written, got %+v", notes)
+	}
+}
+
+func TestPrefetchedDataIsUsedOnce(t *testing.T) {
+	standIn := newGraphqlStandIn(t, graphqlPage(false, "", graphqlNode(1, false)))
+	client := newGraphqlClient(standIn)
+	err := client.Prefetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	standIn.recorded()
+
+	_, _ = client.GetMergeRequestDetails(1)
+	details, err := client.GetMergeRequestDetails(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Title != "from rest" {
+		t.Errorf("second read should use rest api, got %+v", details)
+	}
+	if requests := standIn.recorded(); len(requests) != 1 || !strings.HasPrefix(requests[0], "rest ") {
+		t.Errorf("expected one rest request, got %v", requests)
+	}
+}
+
+func TestDivergedMergeRequestDetailsAreFetchedWithRest(t *testing.T) {
+	standIn := newGraphqlStandIn(t, graphqlPage(false, "", graphqlNode(1, true)))
+	client := newGraphqlClient(standIn)
+	err := client.Prefetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	standIn.recorded()
+
+	details, err := client.GetMergeRequestDetails(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.CommitsBehind != 2 {
+		t.Errorf("number of commits behind should come from rest api, got %+v", details)
+	}
+	pipelines, err := client.GetMergeRequestPipelines(1)
+	if err != nil || len(pipelines) != 2 {
+		t.Errorf("pipelines should still be prefetched, got %+v, %v", pipelines, err)
+	}
+	if requests := standIn.recorded(); len(requests) != 1 {
+		t.Errorf("expected only details request, got %v", requests)
+	}
+}
+
+func TestClearPrefetch(t *testing.T) {
+	standIn := newGraphqlStandIn(t, graphqlPage(false, "", graphqlNode(1, false)))
+	client := newGraphqlClient(standIn)
+	err := client.Prefetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ClearPrefetch()
+	standIn.recorded()
+
+	details, err := client.GetMergeRequestDetails(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelines, err := client.GetMergeRequestPipelines(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.Title != "from rest" || len(pipelines) != 1 || pipelines[0].Id != 800 {
+		t.Errorf("cleared data shouldn't be used, got %+v and %+v", details, pipelines)
+	}
+}
+
+func TestPrefetchFailureFallsBackToRest(t *testing.T) {
+	for name, setUp := range map[string]func(standIn *graphqlStandIn){
+		"graphql error":   func(standIn *graphqlStandIn) { standIn.failure = `{"errors":[{"message":"field doesn't exist"}]}` },
+		"missing project": func(standIn *graphqlStandIn) { standIn.failure = `{"data":{"project":null}}` },
+		"error status":    func(standIn *graphqlStandIn) { standIn.status = http.StatusBadGateway },
+		"invalid global id": func(standIn *graphqlStandIn) {
+			standIn.pages[0] = strings.Replace(standIn.pages[0], "Note/21", "Note/x", 1)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			standIn := newGraphqlStandIn(t, graphqlPage(false, "", graphqlNode(1, false)))
+			setUp(standIn)
+			client := newGraphqlClient(standIn)
+			err := client.Prefetch()
+			if err == nil {
+				t.Fatal("expected prefetch error")
+			}
+			standIn.recorded()
+			details, err := client.GetMergeRequestDetails(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if details.Title != "from rest" {
+				t.Errorf("expected details from rest api, got %+v", details)
+			}
+		})
+	}
+}
+
+func TestPrefetchIsSkippedWithRestBackend(t *testing.T) {
+	standIn := newGraphqlStandIn(t, graphqlPage(false, "", graphqlNode(1, false)))
+	client := New(standIn.URL, "group/app", "me", "token")
+	err := client.Prefetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests := standIn.recorded(); len(requests) > 0 {
+		t.Errorf("rest backend shouldn't send graphql queries, got %v", requests)
+	}
+}
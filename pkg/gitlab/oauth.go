@@ -0,0 +1,242 @@
+package gitlab
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const OAuthAuthorizeEndpoint = "/oauth/authorize"
+const OAuthTokenEndpoint = "/oauth/token"
+const OAuthDeviceEndpoint = "/oauth/authorize_device"
+const oauthScope = "api"
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// refreshMargin is time before expiry when access token is refreshed ahead of time.
+const refreshMargin = time.Minute
+
+type OAuthToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	CreatedAt        int64  `json:"created_at"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// OAuthApp obtains tokens of gitlab OAuth application, the application has to be registered as non-confidential.
+type OAuthApp struct {
+	applicationId string
+	resty         *resty.Client
+}
+
+func NewOAuthApp(gitlabUrl string, applicationId string) *OAuthApp {
+	client := resty.New()
+	client.SetBaseURL(gitlabUrl)
+	client.SetTimeout(defaultTimeout)
+	return &OAuthApp{applicationId: applicationId, resty: client}
+}
+
+// NewPkce returns code verifier and its challenge used to secure authorization code flow.
+func NewPkce() (verifier string, challenge string, err error) {
+	random := make([]byte, 32)
+	_, err = rand.Read(random)
+	if err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(random)
+	hash := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// AuthorizationUrl returns page where the user grants access to the application, gitlab redirects back to redirectUri.
+func (app *OAuthApp) AuthorizationUrl(redirectUri string, state string, codeChallenge string) string {
+	query := url.Values{
+		"client_id":             {app.applicationId},
+		"redirect_uri":          {redirectUri},
+		"response_type":         {"code"},
+		"state":                 {state},
+		"scope":                 {oauthScope},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	return app.resty.BaseURL + OAuthAuthorizeEndpoint + "?" + query.Encode()
+}
+
+// ExchangeCode exchanges code received on redirect for a token.
+func (app *OAuthApp) ExchangeCode(code string, redirectUri string, codeVerifier string) (*OAuthToken, error) {
+	token, _, err := app.requestToken(map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          code,
+		"redirect_uri":  redirectUri,
+		"code_verifier": codeVerifier,
+	})
+	return token, err
+}
+
+// AuthorizeDevice starts device flow, the user has to visit verification page and enter user code.
+func (app *OAuthApp) AuthorizeDevice() (*DeviceAuthorization, error) {
+	var device DeviceAuthorization
+	response, err := app.resty.R().
+		SetFormData(map[string]string{"client_id": app.applicationId, "scope": oauthScope}).
+		SetResult(&device).
+		Post(OAuthDeviceEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("device authorization failed: %v %v", response.Status(), strings.TrimSpace(response.String()))
+	}
+	return &device, nil
+}
+
+// WaitForDevice polls for a token until the user grants or denies access or device code expires.
+func (app *OAuthApp) WaitForDevice(device *DeviceAuthorization) (*OAuthToken, error) {
+	interval := time.Second * time.Duration(device.Interval)
+	if interval <= 0 {
+		interval = time.Second * 5
+	}
+	deadline := time.Now().Add(time.Second * time.Duration(device.ExpiresIn))
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		token, failure, err := app.requestToken(map[string]string{
+			"grant_type":  deviceGrantType,
+			"device_code": device.DeviceCode,
+		})
+		switch failure {
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += time.Second * 5
+			continue
+		}
+		return token, err
+	}
+	return nil, errors.New("device code expired before access was granted")
+}
+
+// Refresh exchanges refresh token for a new token.
+func (app *OAuthApp) Refresh(token OAuthToken) (*OAuthToken, error) {
+	refreshed, _, err := app.requestToken(map[string]string{
+		"grant_type":    "refresh_token",
+		"refresh_token": token.RefreshToken,
+	})
+	return refreshed, err
+}
+
+// requestToken calls token endpoint, OAuth error code is returned together with an error when gitlab refuses the request.
+func (app *OAuthApp) requestToken(form map[string]string) (*OAuthToken, string, error) {
+	form["client_id"] = app.applicationId
+	var result tokenResponse
+	response, err := app.resty.R().
+		SetFormData(form).
+		SetResult(&result).
+		SetError(&result).
+		Post(OAuthTokenEndpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	if response.IsError() || result.Error != "" {
+		return nil, result.Error, fmt.Errorf("gitlab refused to issue a token: %v %v", result.Error, result.ErrorDescription)
+	}
+	createdAt := time.Now()
+	if result.CreatedAt > 0 {
+		createdAt = time.Unix(result.CreatedAt, 0)
+	}
+	token := &OAuthToken{AccessToken: result.AccessToken, RefreshToken: result.RefreshToken}
+	if result.ExpiresIn > 0 {
+		token.ExpiresAt = createdAt.Add(time.Second * time.Duration(result.ExpiresIn))
+	}
+	return token, "", nil
+}
+
+// OAuthSession keeps OAuth token up to date, it's shared by all clients of gitlab instance so the token is refreshed once.
+type OAuthSession struct {
+	app   *OAuthApp
+	token OAuthToken
+	// save persists refreshed token
+	save  func(OAuthToken) error
+	mutex sync.Mutex
+}
+
+func NewOAuthSession(app *OAuthApp, token OAuthToken, save func(OAuthToken) error) *OAuthSession {
+	return &OAuthSession{app: app, token: token, save: save}
+}
+
+// accessToken returns current access token, token which is about to expire is refreshed first.
+func (session *OAuthSession) accessToken() string {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if !session.token.ExpiresAt.IsZero() && time.Now().Add(refreshMargin).After(session.token.ExpiresAt) {
+		session.refreshLocked()
+	}
+	return session.token.AccessToken
+}
+
+// refresh replaces access token rejected by gitlab, true is returned when request should be sent again with a new token.
+func (session *OAuthSession) refresh(rejected string) bool {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.token.AccessToken != rejected {
+		// token was already refreshed by another request
+		return true
+	}
+	return session.refreshLocked()
+}
+
+func (session *OAuthSession) refreshLocked() bool {
+	if session.token.RefreshToken == "" {
+		return false
+	}
+	token, err := session.app.Refresh(session.token)
+	if err != nil {
+		log.Printf("Error when refreshing OAuth token: %v", err)
+		return false
+	}
+	session.token = *token
+	if err := session.save(*token); err != nil {
+		log.Printf("Error when saving refreshed OAuth token: %v", err)
+	}
+	return true
+}
+
+// UseOAuth authenticates requests with access token of the session instead of personal access token, requests
+// rejected with 401 are sent once again after the token is refreshed.
+func (client *ApiClient) UseOAuth(session *OAuthSession) {
+	client.resty.Header.Del(tokenHeader)
+	client.resty.OnBeforeRequest(func(_ *resty.Client, request *resty.Request) error {
+		request.SetAuthToken(session.accessToken())
+		return nil
+	})
+	client.resty.SetRetryCount(1)
+	client.resty.AddRetryCondition(func(response *resty.Response, err error) bool {
+		if response == nil || response.StatusCode() != http.StatusUnauthorized {
+			return false
+		}
+		return session.refresh(response.Request.Token)
+	})
+}
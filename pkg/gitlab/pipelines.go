@@ -1,5 +1,7 @@
 package gitlab
 
+import "time"
+
 type void struct{}
 
 var present = void{}
@@ -33,3 +35,33 @@ func IsAutomaticMergeAllowed(pipelines []MergeRequestPipeline) bool {
 	}
 	return false
 }
+
+var finishedStates = map[string]void{
+	"success": present,
+	"failed":  present,
+}
+
+const pipelineHistorySize = 5
+
+// EstimateRemainingDuration guesses how long the newest running pipeline will still run, based on durations of recently finished pipelines.
+func EstimateRemainingDuration(pipelines []MergeRequestPipeline) (time.Duration, bool) {
+	var running *MergeRequestPipeline
+	var total time.Duration
+	finished := 0
+	for i := range pipelines {
+		pipeline := pipelines[i]
+		if _, exists := runningStates[pipeline.Status]; exists && running == nil {
+			running = &pipelines[i]
+			continue
+		}
+		if _, exists := finishedStates[pipeline.Status]; exists && finished < pipelineHistorySize {
+			total += pipeline.UpdatedAt.Sub(pipeline.CreatedAt)
+			finished++
+		}
+	}
+	if running == nil || finished == 0 {
+		return 0, false
+	}
+	expected := total / time.Duration(finished)
+	return expected - time.Since(running.CreatedAt), true
+}
@@ -0,0 +1,37 @@
+package gitlab
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const filePathParam = "filePath"
+const RepositoryFileEndpoint = "/api/v4/projects/{" + projectIdParam + "}/repository/files/{" + filePathParam + "}/raw"
+
+// RepositoryFile returns content of a file on default branch of the project together with the branch name, content
+// is nil when there is no such file.
+func (client *ApiClient) RepositoryFile(path string) ([]byte, string, error) {
+	project, err := client.ProjectDetails()
+	if err != nil {
+		return nil, "", err
+	}
+	if project.DefaultBranch == "" {
+		// repository is empty
+		return nil, "", nil
+	}
+	response, err := client.resty.R().
+		SetPathParam(projectIdParam, client.projectName).
+		SetPathParam(filePathParam, path).
+		SetQueryParam("ref", project.DefaultBranch).
+		Get(RepositoryFileEndpoint)
+	if err != nil {
+		return nil, "", err
+	}
+	if response.StatusCode() == http.StatusNotFound {
+		return nil, project.DefaultBranch, nil
+	}
+	if response.IsError() {
+		return nil, "", fmt.Errorf("unexpected response status %v", response.Status())
+	}
+	return response.Body(), project.DefaultBranch, nil
+}
@@ -0,0 +1,107 @@
+package gitlab
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"net/url"
+	"os"
+	"time"
+)
+
+const defaultTimeout = time.Second * 10
+
+type TransportConfig struct {
+	// CaBundle is a PEM file with certificates trusted in addition to system ones
+	CaBundle string
+	// ClientCert and ClientKey are PEM files used to authenticate with mutual TLS
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
+	// ProxyUrl overrides proxy taken from HTTP_PROXY and HTTPS_PROXY environment variables
+	ProxyUrl string
+	Timeout  time.Duration
+}
+
+// Transport holds validated TLS, proxy and timeout settings shared by clients of gitlab instance.
+type Transport struct {
+	tlsConfig         *tls.Config
+	proxyUrl          string
+	timeout           time.Duration
+	ClientCertificate *x509.Certificate
+}
+
+// NewTransport loads certificates and checks proxy URL.
+func NewTransport(config TransportConfig) (*Transport, error) {
+	transport := &Transport{
+		tlsConfig: &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify},
+		proxyUrl:  config.ProxyUrl,
+		timeout:   config.Timeout,
+	}
+	if transport.timeout <= 0 {
+		transport.timeout = defaultTimeout
+	}
+	if config.CaBundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		bundle, err := os.ReadFile(config.CaBundle)
+		if err != nil {
+			return nil, fmt.Errorf("CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("CA bundle %v contains no PEM certificates", config.CaBundle)
+		}
+		transport.tlsConfig.RootCAs = pool
+	}
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return nil, errors.New("client certificate and its key have to be given together")
+	}
+	if config.ClientCert != "" {
+		certificate, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		transport.ClientCertificate, err = x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		transport.tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	if config.ProxyUrl != "" {
+		proxy, err := url.Parse(config.ProxyUrl)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("proxy URL %v is not valid", config.ProxyUrl)
+		}
+	}
+	return transport, nil
+}
+
+func (transport *Transport) Insecure() bool {
+	return transport.tlsConfig.InsecureSkipVerify
+}
+
+func (transport *Transport) ProxyUrl() string {
+	return transport.proxyUrl
+}
+
+func (transport *Transport) apply(client *resty.Client) {
+	client.SetTLSClientConfig(transport.tlsConfig.Clone())
+	client.SetTimeout(transport.timeout)
+	if transport.proxyUrl != "" {
+		client.SetProxy(transport.proxyUrl)
+	}
+}
+
+// UseTransport applies TLS, proxy and timeout settings to the client.
+func (client *ApiClient) UseTransport(transport *Transport) {
+	transport.apply(client.resty)
+}
+
+// UseTransport applies TLS, proxy and timeout settings to requests obtaining tokens.
+func (app *OAuthApp) UseTransport(transport *Transport) {
+	transport.apply(app.resty)
+}
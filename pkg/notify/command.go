@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+const commandTimeout = time.Second * 30
+
+// inheritedVariables are passed on to the command, other variables of mergemate, i.e. its tokens, are not.
+var inheritedVariables = []string{"PATH", "HOME", "LANG", "TMPDIR", "SYSTEMROOT", "COMSPEC", "TEMP", "TMP"}
+
+type CommandConfig struct {
+	Command  string
+	Events   []string
+	Template string
+}
+
+type command struct {
+	command string
+}
+
+func newCommand(config CommandConfig) *command {
+	return &command{command: config.Command}
+}
+
+func (c *command) name() string {
+	return "command"
+}
+
+// send runs command through system shell, event is passed as JSON on stdin and rendered message in MERGEMATE_MESSAGE.
+func (c *command) send(event engine.Event, message string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", c.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", c.command)
+	}
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(commandEnvironment(),
+		"MERGEMATE_EVENT="+event.Type,
+		"MERGEMATE_MESSAGE="+message,
+	)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
+}
+
+func commandEnvironment() []string {
+	var environment []string
+	for _, name := range inheritedVariables {
+		if value, found := os.LookupEnv(name); found {
+			environment = append(environment, name+"="+value)
+		}
+	}
+	return environment
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const queueSize = 100
+const defaultTemplate = `{{.Title}} ({{.SourceBranch}} -> {{.TargetBranch}}): {{.Status}}{{if .Reason}}, {{.Reason}}{{end}} {{.WebUrl}}`
+
+type Config struct {
+	Webhook WebhookConfig
+	Smtp    SmtpConfig
+	Command CommandConfig
+}
+
+type backend interface {
+	name() string
+	send(event engine.Event, message string) error
+}
+
+type channel struct {
+	backend  backend
+	events   map[string]bool
+	template *template.Template
+}
+
+type Dispatcher struct {
+	channels []channel
+	events   chan engine.Event
+	done     chan struct{}
+	// closed is set by Close, events channel is closed only while mutex is held, so Notify never sends on closed channel
+	mutex  sync.Mutex
+	closed bool
+}
+
+func New(config Config) (*Dispatcher, error) {
+	dispatcher := &Dispatcher{
+		events: make(chan engine.Event, queueSize),
+		done:   make(chan struct{}),
+	}
+	if config.Webhook.Url != "" {
+		err := dispatcher.register(newWebhook(config.Webhook), config.Webhook.Events, config.Webhook.Template)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.Smtp.Address != "" {
+		err := dispatcher.register(newSmtp(config.Smtp), config.Smtp.Events, config.Smtp.Template)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.Command.Command != "" {
+		err := dispatcher.register(newCommand(config.Command), config.Command.Events, config.Command.Template)
+		if err != nil {
+			return nil, err
+		}
+	}
+	go dispatcher.run()
+	return dispatcher, nil
+}
+
+func (d *Dispatcher) register(backend backend, events []string, messageTemplate string) error {
+	accepted, err := parseEvents(events)
+	if err != nil {
+		return fmt.Errorf("%s notifications: %w", backend.name(), err)
+	}
+	if strings.TrimSpace(messageTemplate) == "" {
+		messageTemplate = defaultTemplate
+	}
+	parsed, err := template.New(backend.name()).Parse(messageTemplate)
+	if err != nil {
+		return fmt.Errorf("%s notifications: invalid template: %w", backend.name(), err)
+	}
+	d.channels = append(d.channels, channel{
+		backend:  backend,
+		events:   accepted,
+		template: parsed,
+	})
+	return nil
+}
+
+// parseEvents returns set of accepted event types, empty set accepts every event.
+func parseEvents(events []string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, eventType := range engine.EventTypes {
+		known[eventType] = true
+	}
+	accepted := make(map[string]bool)
+	for _, eventType := range events {
+		eventType = strings.TrimSpace(eventType)
+		if eventType == "" {
+			continue
+		}
+		if !known[eventType] {
+			return nil, fmt.Errorf("unknown event '%s', supported events: %s", eventType, strings.Join(engine.EventTypes, ","))
+		}
+		accepted[eventType] = true
+	}
+	return accepted, nil
+}
+
+func (c channel) accepts(event engine.Event) bool {
+	return len(c.events) == 0 || c.events[event.Type]
+}
+
+func (c channel) render(event engine.Event) (string, error) {
+	var message bytes.Buffer
+	err := c.template.Execute(&message, event)
+	if err != nil {
+		return "", err
+	}
+	return message.String(), nil
+}
+
+// Notify queues event, events notified after Close are dropped.
+func (d *Dispatcher) Notify(event engine.Event) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.closed {
+		log.Printf("Notifications are closed, dropping %v event of merge request {id = %v}", event.Type, event.MergeRequestIid)
+		return
+	}
+	select {
+	case d.events <- event:
+	default:
+		log.Printf("Notification queue is full, dropping %v event of merge request {id = %v}", event.Type, event.MergeRequestIid)
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for event := range d.events {
+		for _, c := range d.channels {
+			if !c.accepts(event) {
+				continue
+			}
+			message, err := c.render(event)
+			if err != nil {
+				log.Printf("Error when rendering %v notification: %v", c.backend.name(), err)
+				continue
+			}
+			err = c.backend.send(event, message)
+			if err != nil {
+				log.Printf("Error when sending %v notification: %v", c.backend.name(), err)
+			}
+		}
+	}
+}
+
+// Close waits until all queued notifications are sent.
+func (d *Dispatcher) Close() {
+	d.mutex.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.events)
+	}
+	d.mutex.Unlock()
+	<-d.done
+}
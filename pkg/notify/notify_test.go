@@ -0,0 +1,366 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var mergedEvent = engine.Event{
+	Type:            engine.EventMerged,
+	Project:         "group/app",
+	MergeRequestIid: 7,
+	Title:           "Fix login",
+	WebUrl:          "https://gitlab.example.com/group/app/-/merge_requests/7",
+	SourceBranch:    "feature",
+	TargetBranch:    "main",
+	Status:          engine.StatusMerged,
+	Reason:          "pipeline succeeded",
+	Time:            time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+// webhookStandIn records payloads of incoming webhooks.
+type webhookStandIn struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	payloads []webhookPayload
+	status   int
+}
+
+func newWebhookStandIn(t *testing.T, status int) *webhookStandIn {
+	standIn := &webhookStandIn{status: status}
+	standIn.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost || request.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %v with content type %v", request.Method, request.Header.Get("Content-Type"))
+		}
+		var payload webhookPayload
+		err := json.NewDecoder(request.Body).Decode(&payload)
+		if err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		standIn.mutex.Lock()
+		standIn.payloads = append(standIn.payloads, payload)
+		standIn.mutex.Unlock()
+		writer.WriteHeader(standIn.status)
+	}))
+	t.Cleanup(standIn.Close)
+	return standIn
+}
+
+func (s *webhookStandIn) received() []webhookPayload {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.payloads
+}
+
+func TestWebhookPayloadWithDefaultTemplate(t *testing.T) {
+	standIn := newWebhookStandIn(t, http.StatusOK)
+	dispatcher, err := New(Config{Webhook: WebhookConfig{Url: standIn.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Notify(mergedEvent)
+	dispatcher.Close()
+
+	payloads := standIn.received()
+	if len(payloads) != 1 {
+		t.Fatalf("expected 1 payload, got %v", len(payloads))
+	}
+	expected := "Fix login (feature -> main): Merged, pipeline succeeded https://gitlab.example.com/group/app/-/merge_requests/7"
+	if payloads[0].Text != expected {
+		t.Errorf("expected text %q, got %q", expected, payloads[0].Text)
+	}
+	if payloads[0].Event != mergedEvent {
+		t.Errorf("expected event %+v, got %+v", mergedEvent, payloads[0].Event)
+	}
+}
+
+func TestWebhookCustomTemplate(t *testing.T) {
+	standIn := newWebhookStandIn(t, http.StatusNoContent)
+	dispatcher, err := New(Config{Webhook: WebhookConfig{Url: standIn.URL, Template: "{{.Type}} !{{.MergeRequestIid}} in {{.Project}}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Notify(mergedEvent)
+	dispatcher.Close()
+
+	payloads := standIn.received()
+	if len(payloads) != 1 || payloads[0].Text != "merged !7 in group/app" {
+		t.Errorf("unexpected payloads %+v", payloads)
+	}
+}
+
+func TestEventFiltering(t *testing.T) {
+	standIn := newWebhookStandIn(t, http.StatusOK)
+	dispatcher, err := New(Config{Webhook: WebhookConfig{Url: standIn.URL, Events: []string{" ci_failed ", "merge_conflict"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciFailed := mergedEvent
+	ciFailed.Type = engine.EventCiFailed
+	dispatcher.Notify(mergedEvent)
+	dispatcher.Notify(ciFailed)
+	dispatcher.Close()
+
+	payloads := standIn.received()
+	if len(payloads) != 1 || payloads[0].Event.Type != engine.EventCiFailed {
+		t.Errorf("expected only ci_failed event, got %+v", payloads)
+	}
+}
+
+func TestWebhookErrorStatus(t *testing.T) {
+	standIn := newWebhookStandIn(t, http.StatusInternalServerError)
+	err := newWebhook(WebhookConfig{Url: standIn.URL}).send(mergedEvent, "message")
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected error with status 500, got %v", err)
+	}
+}
+
+func TestWebhookUnreachable(t *testing.T) {
+	standIn := newWebhookStandIn(t, http.StatusOK)
+	url := standIn.URL
+	standIn.Close()
+	err := newWebhook(WebhookConfig{Url: url}).send(mergedEvent, "message")
+	if err == nil {
+		t.Error("expected error when webhook can't be reached")
+	}
+}
+
+func TestFailingBackendDoesNotStopOthers(t *testing.T) {
+	failing := newWebhookStandIn(t, http.StatusBadGateway)
+	dispatcher, err := New(Config{Webhook: WebhookConfig{Url: failing.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Notify(mergedEvent)
+	dispatcher.Notify(mergedEvent)
+	dispatcher.Close()
+	if len(failing.received()) != 2 {
+		t.Errorf("expected both events to be sent despite errors, got %v", len(failing.received()))
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	_, err := New(Config{Webhook: WebhookConfig{Url: "http://localhost", Events: []string{"deployed"}}})
+	if err == nil || !strings.Contains(err.Error(), "unknown event 'deployed'") {
+		t.Errorf("expected unknown event error, got %v", err)
+	}
+	_, err = New(Config{Webhook: WebhookConfig{Url: "http://localhost", Template: "{{.Title"}})
+	if err == nil || !strings.Contains(err.Error(), "invalid template") {
+		t.Errorf("expected invalid template error, got %v", err)
+	}
+}
+
+func TestNotifyAfterClose(t *testing.T) {
+	standIn := newWebhookStandIn(t, http.StatusOK)
+	dispatcher, err := New(Config{Webhook: WebhookConfig{Url: standIn.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var senders sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			for j := 0; j < 20; j++ {
+				dispatcher.Notify(mergedEvent)
+			}
+		}()
+	}
+	dispatcher.Close()
+	senders.Wait()
+	dispatcher.Close()
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("command is run with sh")
+	}
+	output := filepath.Join(t.TempDir(), "output")
+	dispatcher, err := New(Config{Command: CommandConfig{
+		Command:  `printf '%s|%s|' "$MERGEMATE_EVENT" "$MERGEMATE_MESSAGE" > ` + output + ` && cat >> ` + output,
+		Template: "{{.Title}} {{.Status}}",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Notify(mergedEvent)
+	dispatcher.Close()
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.SplitN(string(content), "|", 3)
+	if len(parts) != 3 || parts[0] != "merged" || parts[1] != "Fix login Merged" {
+		t.Fatalf("unexpected command output %q", content)
+	}
+	var event engine.Event
+	err = json.Unmarshal([]byte(parts[2]), &event)
+	if err != nil || event != mergedEvent {
+		t.Errorf("expected event %+v on stdin, got %+v (%v)", mergedEvent, event, err)
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("command is run with sh")
+	}
+	err := newCommand(CommandConfig{Command: "echo broken && exit 3"}).send(mergedEvent, "message")
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected error with command output, got %v", err)
+	}
+}
+
+func TestCommandEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("command is run with sh")
+	}
+	t.Setenv("MERGEMATE_API_TOKEN", "secret")
+	output := filepath.Join(t.TempDir(), "output")
+	err := newCommand(CommandConfig{Command: "env > " + output}).send(mergedEvent, "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "secret") || !strings.Contains(string(content), "MERGEMATE_EVENT=merged") {
+		t.Errorf("unexpected command environment %q", content)
+	}
+}
+
+// smtpStandIn accepts a single mail and returns its recipients and data.
+func smtpStandIn(t *testing.T) (string, <-chan []string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	recipients := make(chan []string, 1)
+	data := make(chan string, 1)
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+		reader := bufio.NewReader(connection)
+		reply := func(line string) { connection.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var to []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(command, "RCPT TO:"):
+				to = append(to, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 end with .")
+				var mail strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					mail.WriteString(line)
+				}
+				recipients <- to
+				data <- mail.String()
+				reply("250 OK")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), recipients, data
+}
+
+func TestSmtp(t *testing.T) {
+	address, recipients, data := smtpStandIn(t)
+	dispatcher, err := New(Config{Smtp: SmtpConfig{
+		Address: address,
+		From:    "mergemate@example.com",
+		To:      []string{"dev@example.com", " ", "lead@example.com "},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Notify(mergedEvent)
+	dispatcher.Close()
+
+	select {
+	case to := <-recipients:
+		if strings.Join(to, ",") != "dev@example.com,lead@example.com" {
+			t.Errorf("unexpected recipients %v", to)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("mail wasn't sent")
+	}
+	mail := <-data
+	for _, expected := range []string{
+		"From: mergemate@example.com\r\n",
+		"To: dev@example.com, lead@example.com\r\n",
+		"Subject: [mergemate] merged: Fix login\r\n",
+		"\r\n\r\nFix login (feature -> main): Merged, pipeline succeeded https://gitlab.example.com/group/app/-/merge_requests/7\r\n",
+	} {
+		if !strings.Contains(mail, expected) {
+			t.Errorf("mail %q doesn't contain %q", mail, expected)
+		}
+	}
+}
+
+func TestSmtpSubjectWithLineBreak(t *testing.T) {
+	address, recipients, data := smtpStandIn(t)
+	dispatcher, err := New(Config{Smtp: SmtpConfig{Address: address, From: "mergemate@example.com", To: []string{"dev@example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := mergedEvent
+	event.Title = "Fix login\r\nBcc: someone@example.com"
+	dispatcher.Notify(event)
+	dispatcher.Close()
+
+	select {
+	case <-recipients:
+	case <-time.After(time.Second * 5):
+		t.Fatal("mail wasn't sent")
+	}
+	headers := strings.SplitN(<-data, "\r\n\r\n", 2)[0]
+	if strings.Contains(headers, "\r\nBcc:") {
+		t.Errorf("title added header to mail %q", headers)
+	}
+}
+
+func TestSmtpUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	err = newSmtp(SmtpConfig{Address: address, From: "a@example.com", To: []string{"b@example.com"}}).send(mergedEvent, "message")
+	if err == nil {
+		t.Error("expected error when smtp server can't be reached")
+	}
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SmtpConfig struct {
+	Address  string
+	User     string
+	Password string
+	From     string
+	To       []string
+	Events   []string
+	Template string
+}
+
+type smtpMailer struct {
+	config SmtpConfig
+}
+
+func newSmtp(config SmtpConfig) *smtpMailer {
+	return &smtpMailer{config: config}
+}
+
+func (s *smtpMailer) name() string {
+	return "smtp"
+}
+
+func (s *smtpMailer) send(event engine.Event, message string) error {
+	var auth smtp.Auth
+	if s.config.User != "" {
+		host, _, err := net.SplitHostPort(s.config.Address)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.config.User, s.config.Password, host)
+	}
+	var recipients []string
+	for _, recipient := range s.config.To {
+		if strings.TrimSpace(recipient) != "" {
+			recipients = append(recipients, strings.TrimSpace(recipient))
+		}
+	}
+	mail := strings.Builder{}
+	mail.WriteString("From: " + s.config.From + "\r\n")
+	mail.WriteString("To: " + strings.Join(recipients, ", ") + "\r\n")
+	// title is encoded when needed, line breaks in it can't add headers
+	subject := mime.QEncoding.Encode("utf-8", fmt.Sprintf("[mergemate] %s: %s", event.Type, event.Title))
+	mail.WriteString("Subject: " + subject + "\r\n")
+	mail.WriteString("Date: " + event.Time.Format(time.RFC1123Z) + "\r\n")
+	mail.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	mail.WriteString("\r\n")
+	mail.WriteString(strings.ReplaceAll(message, "\n", "\r\n"))
+	mail.WriteString("\r\n")
+	return smtp.SendMail(s.config.Address, auth, s.config.From, recipients, []byte(mail.String()))
+}
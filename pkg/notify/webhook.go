@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"net/http"
+	"time"
+)
+
+type WebhookConfig struct {
+	Url      string
+	Events   []string
+	Template string
+}
+
+// webhookPayload carries rendered message in the text field understood by Slack and Mattermost incoming webhooks.
+type webhookPayload struct {
+	Text  string       `json:"text"`
+	Event engine.Event `json:"event"`
+}
+
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhook(config WebhookConfig) *webhook {
+	return &webhook{
+		url:    config.Url,
+		client: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (w *webhook) name() string {
+	return "webhook"
+}
+
+func (w *webhook) send(event engine.Event, message string) error {
+	body, err := json.Marshal(webhookPayload{Text: message, Event: event})
+	if err != nil {
+		return err
+	}
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %v", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package offline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	ActionCreateMergeRequest = "create_merge_request"
+	ActionMergeAutomatically = "merge_automatically"
+)
+
+// Action is a user action taken while gitlab was not reachable.
+type Action struct {
+	Kind            string    `json:"kind"`
+	Project         string    `json:"project"`
+	SourceBranch    string    `json:"source_branch,omitempty"`
+	TargetBranch    string    `json:"target_branch,omitempty"`
+	Title           string    `json:"title,omitempty"`
+	MergeRequestIid int       `json:"merge_request_iid,omitempty"`
+	QueuedAt        time.Time `json:"queued_at"`
+}
+
+func (action Action) String() string {
+	switch action.Kind {
+	case ActionCreateMergeRequest:
+		return fmt.Sprintf("create merge request from %s to %s in %s", action.SourceBranch, action.TargetBranch, action.Project)
+	case ActionMergeAutomatically:
+		return fmt.Sprintf("merge automatically merge request %s!%d", action.Project, action.MergeRequestIid)
+	default:
+		return action.Kind
+	}
+}
+
+// Outcome of replayed action, Err is set when action failed or conflicts with changes made in gitlab in the meantime.
+type Outcome struct {
+	Action       Action
+	MergeRequest *gitlab.MergeRequestDetails
+	Err          error
+}
+
+// Queue is a durable queue of actions, they are replayed once gitlab is reachable again.
+type Queue struct {
+	path    string
+	actions []Action
+	mutex   sync.Mutex
+	// replaying serializes replays, so an action isn't replayed twice, mutex isn't held while requests are sent
+	replaying sync.Mutex
+}
+
+func NewQueue(path string) (*Queue, error) {
+	queue := &Queue{path: path}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return queue, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &queue.actions)
+	if err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// Add queues action, action has to name the project it's replayed in.
+func (queue *Queue) Add(action Action) error {
+	if action.Project == "" {
+		return fmt.Errorf("project of action %v is not known", action)
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	action.QueuedAt = time.Now()
+	queue.actions = append(queue.actions, action)
+	return queue.save()
+}
+
+func (queue *Queue) Len() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	return len(queue.actions)
+}
+
+// Replay executes queued actions in order using clients of their projects, it stops at first action which fails because
+// gitlab is still not reachable. Actions added during replay are left for the next one.
+func (queue *Queue) Replay(clients func(project string) *gitlab.ApiClient) []Outcome {
+	queue.replaying.Lock()
+	defer queue.replaying.Unlock()
+	queue.mutex.Lock()
+	actions := append([]Action(nil), queue.actions...)
+	queue.mutex.Unlock()
+	var outcomes []Outcome
+	for _, action := range actions {
+		var mergeRequest *gitlab.MergeRequestDetails
+		var err error
+		if client := clients(action.Project); client != nil {
+			mergeRequest, err = replay(client, action)
+		} else {
+			err = fmt.Errorf("project %v is not configured", action.Project)
+		}
+		if action.Kind == ActionCreateMergeRequest && mergeRequest != nil && IsOffline(err) {
+			// merge request was created, only the marker is left to be added once gitlab is reachable
+			if err := queue.replaceFirst(markAction(action, mergeRequest.Iid)); err != nil {
+				outcomes = append(outcomes, Outcome{Action: action, MergeRequest: mergeRequest, Err: err})
+			}
+			break
+		}
+		if IsOffline(err) {
+			break
+		}
+		outcomes = append(outcomes, Outcome{Action: action, MergeRequest: mergeRequest, Err: err})
+		if err := queue.removeFirst(); err != nil {
+			outcomes = append(outcomes, Outcome{Action: action, Err: err})
+			break
+		}
+	}
+	return outcomes
+}
+
+// replaceFirst replaces action which is being replayed, actions are only appended by Add, so it's still the first one.
+func (queue *Queue) replaceFirst(action Action) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.actions[0] = action
+	return queue.save()
+}
+
+// markAction marks merge request created by action to be merged automatically.
+func markAction(action Action, mergeRequestIid int) Action {
+	return Action{
+		Kind:            ActionMergeAutomatically,
+		Project:         action.Project,
+		MergeRequestIid: mergeRequestIid,
+		QueuedAt:        action.QueuedAt,
+	}
+}
+
+// removeFirst removes replayed action, actions are only appended by Add, so it's still the first one.
+func (queue *Queue) removeFirst() error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	queue.actions = queue.actions[1:]
+	return queue.save()
+}
+
+func replay(client *gitlab.ApiClient, action Action) (*gitlab.MergeRequestDetails, error) {
+	switch action.Kind {
+	case ActionCreateMergeRequest:
+		mergeRequest, err := client.CreateMergeRequest(action.SourceBranch, action.TargetBranch, action.Title)
+		if err != nil {
+			return nil, err
+		}
+		_, err = client.CreateMergeRequestNote(mergeRequest.Iid, engine.MergeAutomatically)
+		return mergeRequest, err
+	case ActionMergeAutomatically:
+		mergeRequest, err := client.GetMergeRequestDetails(action.MergeRequestIid)
+		if err != nil {
+			return nil, err
+		}
+		if mergeRequest.State != "opened" {
+			return mergeRequest, fmt.Errorf("merge request is already %s", mergeRequest.State)
+		}
+		_, err = client.CreateMergeRequestNote(mergeRequest.Iid, engine.MergeAutomatically)
+		return mergeRequest, err
+	default:
+		return nil, fmt.Errorf("unknown action %s", action.Kind)
+	}
+}
+
+func (queue *Queue) save() error {
+	content, err := json.Marshal(queue.actions)
+	if err != nil {
+		return err
+	}
+	return writeFile(queue.path, content)
+}
@@ -0,0 +1,74 @@
+package offline
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type snapshot struct {
+	SavedAt time.Time       `json:"saved_at"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Store keeps last successful responses on disk, so they can be shown when gitlab is not reachable.
+type Store struct {
+	dir string
+}
+
+func New(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// IsOffline tells if request failed because gitlab couldn't be reached or requests are suspended after repeated failures.
+func IsOffline(err error) bool {
+	return errors.As(err, new(net.Error)) || errors.Is(err, gitlab.CircuitOpen)
+}
+
+func (store *Store) Save(name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(snapshot{SavedAt: time.Now(), Data: data})
+	if err != nil {
+		return err
+	}
+	return writeFile(store.path(name), content)
+}
+
+// Load reads saved response into value and returns the time it was saved at.
+func (store *Store) Load(name string, value interface{}) (time.Time, error) {
+	content, err := os.ReadFile(store.path(name))
+	if err != nil {
+		return time.Time{}, err
+	}
+	var saved snapshot
+	err = json.Unmarshal(content, &saved)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return saved.SavedAt, json.Unmarshal(saved.Data, value)
+}
+
+func (store *Store) path(name string) string {
+	return filepath.Join(store.dir, name+".json")
+}
+
+// writeFile replaces file content atomically, so a crash never leaves half written file behind.
+func writeFile(path string, content []byte) error {
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const tokenHeader = "X-Gitlab-Token"
+const eventsBufferSize = 100
+
+const (
+	KindMergeRequest = "merge_request"
+	KindPipeline     = "pipeline"
+	KindNote         = "note"
+)
+
+// Event points to merge request affected by a gitlab webhook, pipeline events without merge request carry only the branch.
+type Event struct {
+	Project         string
+	Kind            string
+	Action          string
+	MergeRequestIid int
+	SourceBranch    string
+}
+
+type Config struct {
+	ListenAddress string
+	Secret        string
+	// Projects accepted by the server, either path with namespace or id, all projects are accepted when empty
+	Projects []string
+	// NamePrefix is prepended to project names of events, it tells apart projects of profiles shown side by side
+	NamePrefix string
+}
+
+type Server struct {
+	config    Config
+	http      *http.Server
+	events    chan Event
+	mutex     sync.Mutex
+	lastEvent time.Time
+}
+
+type hookPayload struct {
+	ObjectKind string `json:"object_kind"`
+	Project    struct {
+		Id                int    `json:"id"`
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		Iid          int    `json:"iid"`
+		Action       string `json:"action"`
+		Ref          string `json:"ref"`
+		SourceBranch string `json:"source_branch"`
+		NoteableType string `json:"noteable_type"`
+	} `json:"object_attributes"`
+	MergeRequest *struct {
+		Iid          int    `json:"iid"`
+		SourceBranch string `json:"source_branch"`
+	} `json:"merge_request"`
+}
+
+func New(config Config) *Server {
+	server := &Server{
+		config: config,
+		events: make(chan Event, eventsBufferSize),
+	}
+	server.http = &http.Server{
+		Handler:           server,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	return server
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.ListenAddress)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening for gitlab webhooks on %v", listener.Addr())
+	go func() {
+		err := s.http.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Webhook server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Close() error {
+	return s.http.Close()
+}
+
+func (s *Server) Events() <-chan Event {
+	return s.events
+}
+
+// LastEvent returns time of the most recent accepted event, zero when nothing arrived yet.
+func (s *Server) LastEvent() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastEvent
+}
+
+func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token := request.Header.Get(tokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Secret)) != 1 {
+		log.Printf("Rejected webhook from %v, invalid secret token", request.RemoteAddr)
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var payload hookPayload
+	err := json.NewDecoder(http.MaxBytesReader(writer, request.Body, 1<<22)).Decode(&payload)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	event, ok := s.toEvent(payload)
+	if ok {
+		s.mutex.Lock()
+		s.lastEvent = time.Now()
+		s.mutex.Unlock()
+		select {
+		case s.events <- event:
+		default:
+			log.Printf("Webhook events buffer is full, dropping %v event", event.Kind)
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) toEvent(payload hookPayload) (Event, bool) {
+	project, accepted := s.project(payload)
+	if !accepted {
+		return Event{}, false
+	}
+	if s.config.NamePrefix != "" {
+		project = s.config.NamePrefix + ":" + project
+	}
+	event := Event{Project: project, Kind: payload.ObjectKind, Action: payload.ObjectAttributes.Action}
+	switch payload.ObjectKind {
+	case KindMergeRequest:
+		event.MergeRequestIid = payload.ObjectAttributes.Iid
+		event.SourceBranch = payload.ObjectAttributes.SourceBranch
+	case KindPipeline:
+		event.SourceBranch = payload.ObjectAttributes.Ref
+		if payload.MergeRequest != nil {
+			event.MergeRequestIid = payload.MergeRequest.Iid
+			event.SourceBranch = payload.MergeRequest.SourceBranch
+		}
+	case KindNote:
+		if payload.ObjectAttributes.NoteableType != "MergeRequest" || payload.MergeRequest == nil {
+			return Event{}, false
+		}
+		event.MergeRequestIid = payload.MergeRequest.Iid
+		event.SourceBranch = payload.MergeRequest.SourceBranch
+	default:
+		return Event{}, false
+	}
+	return event, true
+}
+
+// project returns configured name of the project which sent the webhook.
+func (s *Server) project(payload hookPayload) (string, bool) {
+	if len(s.config.Projects) == 0 {
+		return payload.Project.PathWithNamespace, true
+	}
+	for _, project := range s.config.Projects {
+		if project == payload.Project.PathWithNamespace || project == strconv.Itoa(payload.Project.Id) {
+			return project, true
+		}
+	}
+	return "", false
+}
@@ -4,3 +4,4 @@ import "github.com/charmbracelet/lipgloss"
 
 const Emerald800 = lipgloss.Color("#065f46")
 const Emerald600 = lipgloss.Color("#059669")
+const Amber500 = lipgloss.Color("#f59e0b")
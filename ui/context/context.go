@@ -1,23 +1,248 @@
 package context
 
 import (
+	"github.com/aprokopczyk/mergemate/pkg/engine"
 	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/aprokopczyk/mergemate/pkg/offline"
+	"github.com/aprokopczyk/mergemate/pkg/webhook"
+	"github.com/aprokopczyk/mergemate/ui/keys"
 	"github.com/aprokopczyk/mergemate/ui/styles"
+	"strings"
+	"sync"
+	"time"
 )
 
 type AppContext struct {
-	TableContentHeight   int
-	HelpHeight           int
-	WindowWidth          int
-	WindowHeight         int
-	TablePageSize        int
-	MergeJobInterval     int
-	Styles               styles.Styles
+	TableContentHeight int
+	HelpHeight         int
+	WindowWidth        int
+	WindowHeight       int
+	TablePageSize      int
+	Styles             styles.Styles
+	Groups             []*Group
+	// ProjectFilter is the name of the only project shown in tabs, all projects are shown when empty
+	ProjectFilter string
+	WebhookServer *webhook.Server
+	OfflineStore  *offline.Store
+	ActionQueue   *offline.Queue
+	// InsecureConnection is set when TLS certificate of gitlab isn't verified
+	InsecureConnection bool
+	// projects are guarded by mutex, projects found in groups are added by background jobs
+	projects      []*Project
+	projectsMutex sync.Mutex
+	// refresh and merge job intervals are read by background jobs and changed when config is reloaded
+	refreshInterval         int
+	mergeJobInterval        int
+	webhookFallbackInterval int
+	settingsMutex           sync.Mutex
+}
+
+type Project struct {
+	Name                 string
 	GitlabClient         *gitlab.ApiClient
+	MergeEngine          *engine.Engine
 	UserBranchPrefix     string
 	TargetBranchPrefixes []string
-	FavouriteBranches    []string
+	FavouriteBranches    []keys.Favourite
+	// Discovered is set for projects found in a group, their merge requests are listed with the group
+	Discovered bool
+}
+
+// Group lists merge requests of all its projects with a single client.
+type Group struct {
+	Name         string
+	GitlabClient *gitlab.ApiClient
+	// Prefix is prepended to names of projects found in the group, see ProjectName
+	Prefix string
+	// NewProject creates project found in the group
+	NewProject func(path string) *Project
+}
+
+// ProjectName returns name of a project, prefix tells apart projects of different profiles shown side by side.
+func ProjectName(prefix string, path string) string {
+	if prefix == "" {
+		return path
+	}
+	return prefix + ":" + path
+}
+
+func (group *Group) projectPath(name string) (string, bool) {
+	if group.Prefix == "" {
+		return name, true
+	}
+	prefix := group.Prefix + ":"
+	if !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, prefix), true
 }
 
 type UpdatedContextMessage struct {
 }
+
+// ReloadedConfigMessage is sent when config file changed, Apply re-applies settings which can be changed while running.
+type ReloadedConfigMessage struct {
+	Apply func(context *AppContext)
+	// Applied and RestartRequired list changed config entries
+	Applied         []string
+	RestartRequired []string
+	// Err is set when changed config couldn't be read, nothing is applied then
+	Err error
+}
+
+func (context *AppContext) RefreshInterval() int {
+	context.settingsMutex.Lock()
+	defer context.settingsMutex.Unlock()
+	return context.refreshInterval
+}
+
+func (context *AppContext) SetRefreshInterval(seconds int) {
+	context.settingsMutex.Lock()
+	defer context.settingsMutex.Unlock()
+	context.refreshInterval = seconds
+}
+
+// MergeJobInterval is used when no merge engine asked for the next run, i.e. there are no projects yet.
+func (context *AppContext) MergeJobInterval() time.Duration {
+	context.settingsMutex.Lock()
+	defer context.settingsMutex.Unlock()
+	return time.Second * time.Duration(context.mergeJobInterval)
+}
+
+// WebhookFallback returns interval of merge job used instead of the one asked by merge engines, as long as webhooks keep
+// arriving, merge requests are evaluated on incoming webhooks then.
+func (context *AppContext) WebhookFallback() (time.Duration, bool) {
+	context.settingsMutex.Lock()
+	fallback := time.Second * time.Duration(context.webhookFallbackInterval)
+	context.settingsMutex.Unlock()
+	if context.WebhookServer == nil {
+		return 0, false
+	}
+	lastEvent := context.WebhookServer.LastEvent()
+	return fallback, !lastEvent.IsZero() && time.Since(lastEvent) < fallback
+}
+
+func (context *AppContext) SetMergeJobInterval(seconds int, webhookFallbackSeconds int) {
+	context.settingsMutex.Lock()
+	defer context.settingsMutex.Unlock()
+	context.mergeJobInterval = seconds
+	context.webhookFallbackInterval = webhookFallbackSeconds
+}
+
+func (context *AppContext) AddProject(project *Project) {
+	context.projectsMutex.Lock()
+	defer context.projectsMutex.Unlock()
+	context.projects = append(context.projects, project)
+}
+
+func (context *AppContext) Projects() []*Project {
+	context.projectsMutex.Lock()
+	defer context.projectsMutex.Unlock()
+	return append([]*Project(nil), context.projects...)
+}
+
+// ReplaceProject swaps project of the same name, background jobs which already got the old project keep using it.
+func (context *AppContext) ReplaceProject(project *Project) {
+	context.projectsMutex.Lock()
+	defer context.projectsMutex.Unlock()
+	for i, existing := range context.projects {
+		if existing.Name == project.Name {
+			context.projects[i] = project
+		}
+	}
+}
+
+func (context *AppContext) Project(name string) *Project {
+	for _, project := range context.Projects() {
+		if project.Name == name {
+			return project
+		}
+	}
+	return nil
+}
+
+// DiscoveredProject returns project found in a group, it's created when it's seen for the first time.
+func (context *AppContext) DiscoveredProject(group *Group, path string) *Project {
+	context.projectsMutex.Lock()
+	defer context.projectsMutex.Unlock()
+	name := ProjectName(group.Prefix, path)
+	for _, project := range context.projects {
+		if project.Name == name {
+			return project
+		}
+	}
+	project := group.NewProject(path)
+	project.Discovered = true
+	context.projects = append(context.projects, project)
+	return project
+}
+
+// IsShown tells if data of a project passes the project filter.
+func (context *AppContext) IsShown(project string) bool {
+	return context.ProjectFilter == "" || context.ProjectFilter == project
+}
+
+// NextProjectFilter switches the filter to the next project, after the last project all projects are shown again.
+func (context *AppContext) NextProjectFilter() {
+	projects := context.Projects()
+	if context.ProjectFilter == "" {
+		if len(projects) > 0 {
+			context.ProjectFilter = projects[0].Name
+		}
+		return
+	}
+	for i, project := range projects {
+		if project.Name == context.ProjectFilter {
+			if i+1 < len(projects) {
+				context.ProjectFilter = projects[i+1].Name
+			} else {
+				context.ProjectFilter = ""
+			}
+			return
+		}
+	}
+	context.ProjectFilter = ""
+}
+
+var healthSeverity = map[gitlab.Health]int{
+	gitlab.HealthConnected:   0,
+	gitlab.HealthDegraded:    1,
+	gitlab.HealthRateLimited: 2,
+	gitlab.HealthOffline:     3,
+}
+
+// Health returns the worst connection health of all projects and groups.
+func (context *AppContext) Health() gitlab.Health {
+	worst := gitlab.HealthConnected
+	var clients []*gitlab.ApiClient
+	for _, project := range context.Projects() {
+		clients = append(clients, project.GitlabClient)
+	}
+	for _, group := range context.Groups {
+		clients = append(clients, group.GitlabClient)
+	}
+	for _, client := range clients {
+		health, _ := client.Health()
+		if healthSeverity[health] > healthSeverity[worst] {
+			worst = health
+		}
+	}
+	return worst
+}
+
+// GitlabClient returns client of a project, projects which aren't known yet are looked for in groups.
+func (context *AppContext) GitlabClient(name string) *gitlab.ApiClient {
+	project := context.Project(name)
+	for _, group := range context.Groups {
+		if project != nil {
+			break
+		}
+		if path, found := group.projectPath(name); found {
+			project = context.DiscoveredProject(group, path)
+		}
+	}
+	if project == nil {
+		return nil
+	}
+	return project.GitlabClient
+}
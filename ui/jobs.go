@@ -0,0 +1,182 @@
+package ui
+
+import (
+	"github.com/aprokopczyk/mergemate/pkg/engine"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/aprokopczyk/mergemate/ui/context"
+	"github.com/aprokopczyk/mergemate/ui/scheduler"
+	"github.com/aprokopczyk/mergemate/ui/tabs"
+	tea "github.com/charmbracelet/bubbletea"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func (ui *UI) backgroundJobs() []scheduler.Job {
+	return []scheduler.Job{
+		{Name: tabs.ActiveMergeRequestsJob, Run: ui.listActiveMergeRequests},
+		{Name: tabs.MergedMergeRequestsJob, Run: ui.listMergedMergeRequests},
+		{Name: tabs.UserBranchesJob, Run: ui.listUserBranches},
+		{Name: tabs.TargetBranchesJob, Run: ui.listTargetBranches},
+		{Name: tabs.MergeJob, Run: ui.processMergeRequests},
+		{Name: tabs.ActionQueueJob, Run: ui.replayQueuedActions},
+	}
+}
+
+func (ui *UI) refreshInterval() time.Duration {
+	return time.Second * time.Duration(ui.context.RefreshInterval())
+}
+
+func (ui *UI) listActiveMergeRequests() (tea.Msg, time.Duration) {
+	mergeRequests, staleSince := ui.listMergeRequests(tabs.ActiveMergeRequestsJob, (*gitlab.ApiClient).OpenedMergeRequests)
+	return tabs.ActiveMergeRequests{MergeRequests: mergeRequests, StaleSince: staleSince}, ui.refreshInterval()
+}
+
+func (ui *UI) listMergedMergeRequests() (tea.Msg, time.Duration) {
+	mergeRequests, staleSince := ui.listMergeRequests(tabs.MergedMergeRequestsJob, (*gitlab.ApiClient).MergedMergeRequests)
+	return tabs.MergedMergeRequests{MergeRequests: mergeRequests, StaleSince: staleSince}, ui.refreshInterval()
+}
+
+func (ui *UI) listMergeRequests(name string, list func(*gitlab.ApiClient) ([]gitlab.MergeRequestDetails, error)) (map[string][]gitlab.MergeRequestDetails, time.Time) {
+	result := make(map[string][]gitlab.MergeRequestDetails)
+	var staleSince time.Time
+	for _, project := range ui.context.Projects() {
+		if project.Discovered {
+			continue
+		}
+		mergeRequests, err := list(project.GitlabClient)
+		savedAt := ui.snapshot(name, project.Name, &mergeRequests, err)
+		staleSince = oldest(staleSince, savedAt)
+		result[project.Name] = mergeRequests
+	}
+	for _, group := range ui.context.Groups {
+		mergeRequests, err := list(group.GitlabClient)
+		// groups of different profiles can have the same name
+		savedAt := ui.snapshot(name, "group-"+context.ProjectName(group.Prefix, group.Name), &mergeRequests, err)
+		staleSince = oldest(staleSince, savedAt)
+		grouped := byProject(mergeRequests)
+		paths := make([]string, 0, len(grouped))
+		for path := range grouped {
+			paths = append(paths, path)
+		}
+		// sorted, so that the project filter goes through discovered projects alphabetically
+		sort.Strings(paths)
+		for _, path := range paths {
+			projectName := context.ProjectName(group.Prefix, path)
+			// configured projects which belong to the group are already listed
+			if _, listed := result[projectName]; listed {
+				continue
+			}
+			ui.context.DiscoveredProject(group, path)
+			result[projectName] = grouped[path]
+		}
+	}
+	return result, staleSince
+}
+
+func byProject(mergeRequests []gitlab.MergeRequestDetails) map[string][]gitlab.MergeRequestDetails {
+	result := make(map[string][]gitlab.MergeRequestDetails)
+	for _, mergeRequest := range mergeRequests {
+		projectName := mergeRequest.ProjectPath()
+		result[projectName] = append(result[projectName], mergeRequest)
+	}
+	return result
+}
+
+func (ui *UI) listUserBranches() (tea.Msg, time.Duration) {
+	branches, staleSince := ui.listBranches(tabs.UserBranchesJob, func(project *context.Project) []string {
+		return []string{project.UserBranchPrefix}
+	})
+	return tabs.UserBranches{Branches: branches, StaleSince: staleSince}, ui.refreshInterval()
+}
+
+func (ui *UI) listTargetBranches() (tea.Msg, time.Duration) {
+	branches, staleSince := ui.listBranches(tabs.TargetBranchesJob, func(project *context.Project) []string {
+		return project.TargetBranchPrefixes
+	})
+	return tabs.TargetBranches{Branches: branches, StaleSince: staleSince}, ui.refreshInterval()
+}
+
+func (ui *UI) listBranches(name string, patterns func(*context.Project) []string) (map[string][]gitlab.Branch, time.Time) {
+	result := make(map[string][]gitlab.Branch)
+	var staleSince time.Time
+	for _, project := range ui.context.Projects() {
+		branches, err := project.GitlabClient.FetchBranchesWithPattern(patterns(project))
+		savedAt := ui.snapshot(name, project.Name, &branches, err)
+		staleSince = oldest(staleSince, savedAt)
+		result[project.Name] = branches
+	}
+	return result, staleSince
+}
+
+// snapshot saves successfully fetched list on disk, when fetching failed previously saved list is loaded into value
+// and time it was saved at is returned.
+func (ui *UI) snapshot(name string, project string, value interface{}, err error) time.Time {
+	name = name + "-" + strings.NewReplacer("/", "_", ":", "_").Replace(project)
+	if err == nil {
+		err = ui.context.OfflineStore.Save(name, value)
+		if err != nil {
+			log.Printf("Error when saving %v for offline use %v", name, err)
+		}
+		return time.Time{}
+	}
+	log.Printf("Error when fetching %v %v", name, err)
+	savedAt, err := ui.context.OfflineStore.Load(name, value)
+	if err != nil {
+		log.Printf("Error when loading %v saved for offline use %v", name, err)
+		return time.Time{}
+	}
+	return savedAt
+}
+
+func oldest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+func (ui *UI) replayQueuedActions() (tea.Msg, time.Duration) {
+	if ui.context.ActionQueue.Len() == 0 {
+		return nil, ui.refreshInterval()
+	}
+	outcomes := ui.context.ActionQueue.Replay(ui.context.GitlabClient)
+	if len(outcomes) == 0 {
+		return nil, ui.refreshInterval()
+	}
+	return tabs.QueuedActionsReplayed{Outcomes: outcomes}, ui.refreshInterval()
+}
+
+// processMergeRequests runs merge engines of all projects in parallel, next run is scheduled when the earliest project needs it.
+func (ui *UI) processMergeRequests() (tea.Msg, time.Duration) {
+	results := make(map[string]engine.Result)
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	for _, project := range ui.context.Projects() {
+		wait.Add(1)
+		go func(project *context.Project) {
+			defer wait.Done()
+			result := project.MergeEngine.ProcessTracked()
+			mutex.Lock()
+			results[project.Name] = result
+			mutex.Unlock()
+		}(project)
+	}
+	wait.Wait()
+	var nextRun time.Duration
+	for _, result := range results {
+		if result.NextRun > 0 && (nextRun == 0 || result.NextRun < nextRun) {
+			nextRun = result.NextRun
+		}
+	}
+	if fallback, arriving := ui.context.WebhookFallback(); arriving {
+		return tabs.MergeRequestProcessingResult{Results: results}, fallback
+	}
+	// there may be no projects yet when only groups are configured
+	if nextRun == 0 {
+		nextRun = ui.context.MergeJobInterval()
+	}
+	return tabs.MergeRequestProcessingResult{Results: results}, nextRun
+}
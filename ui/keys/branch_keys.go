@@ -10,19 +10,36 @@ type BranchKeyMap struct {
 	CloseTargetBranchesList key.Binding
 	SelectTargetBranch      key.Binding
 	MergeFavourite          []key.Binding
+	// MergeFavouriteTargets holds target branch of every MergeFavourite binding
+	MergeFavouriteTargets []string
 }
 
-func BranchHelp(branchTargets []string) BranchKeyMap {
+// Favourite is a target branch with a shortcut, position of the branch on the list is used when key is empty.
+type Favourite struct {
+	Branch string
+	Key    string
+}
+
+// key returns shortcut of favourite at given position on the list.
+func (favourite Favourite) key(position int) string {
+	if favourite.Key == "" {
+		return strconv.Itoa(position)
+	}
+	return favourite.Key
+}
+
+func BranchHelp(favourites []Favourite) BranchKeyMap {
 	branchKeyMap := BranchKeyMap{
-		MergeAutomatically:      key.NewBinding(key.WithKeys("m"), key.WithHelp("m", "Create automatic merge request")),
+		MergeAutomatically:      key.NewBinding(key.WithKeys(mergeAutomaticallyKeys...), key.WithHelp(helpKeys(mergeAutomaticallyKeys), "Create automatic merge request")),
 		CloseTargetBranchesList: key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "Close target branches list"), key.WithDisabled()),
 		SelectTargetBranch:      key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "Select target branch"), key.WithDisabled()),
 	}
 
-	for i, branch := range branchTargets {
-		if branch != "" {
-			keySymbol := strconv.Itoa(i)
-			branchKeyMap.MergeFavourite = append(branchKeyMap.MergeFavourite, key.NewBinding(key.WithKeys(keySymbol), key.WithHelp(keySymbol, "Merge automatically to "+branch)))
+	for i, favourite := range favourites {
+		if favourite.Branch != "" {
+			keySymbol := favourite.key(i)
+			branchKeyMap.MergeFavourite = append(branchKeyMap.MergeFavourite, key.NewBinding(key.WithKeys(keySymbol), key.WithHelp(keySymbol, "Merge automatically to "+favourite.Branch)))
+			branchKeyMap.MergeFavouriteTargets = append(branchKeyMap.MergeFavouriteTargets, favourite.Branch)
 		}
 	}
 
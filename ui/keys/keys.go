@@ -1,8 +1,11 @@
 package keys
 
 import (
+	"fmt"
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
+	"sort"
+	"strings"
 )
 
 type keyMap struct {
@@ -11,6 +14,7 @@ type keyMap struct {
 	Left                  key.Binding
 	Right                 key.Binding
 	Quit                  key.Binding
+	FilterProject         key.Binding
 	ActiveContextBindings []key.Binding
 }
 
@@ -19,7 +23,7 @@ func (k keyMap) ShortHelp() []key.Binding {
 }
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
-		{k.Up, k.Down, k.Left, k.Right, k.Quit},
+		{k.Up, k.Down, k.Left, k.Right, k.FilterProject, k.Quit},
 		k.ActiveContextBindings,
 	}
 }
@@ -31,9 +35,97 @@ func GetKeyMap(activeContextBindings []key.Binding) help.KeyMap {
 }
 
 var Keys = keyMap{
-	Up:    key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "move up")),
-	Down:  key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "move down")),
-	Left:  key.NewBinding(key.WithKeys("left"), key.WithHelp("←", "Switch to left tab")),
-	Right: key.NewBinding(key.WithKeys("right"), key.WithHelp("→", "Switch to right tab")),
-	Quit:  key.NewBinding(key.WithKeys("ctrl+c"), key.WithHelp("ctrl+c", "Quit")),
+	Up:            key.NewBinding(key.WithKeys("up", "k"), key.WithHelp("↑/k", "move up")),
+	Down:          key.NewBinding(key.WithKeys("down", "j"), key.WithHelp("↓/j", "move down")),
+	Left:          key.NewBinding(key.WithKeys("left"), key.WithHelp("←", "Switch to left tab")),
+	Right:         key.NewBinding(key.WithKeys("right"), key.WithHelp("→", "Switch to right tab")),
+	Quit:          key.NewBinding(key.WithKeys("ctrl+c"), key.WithHelp("ctrl+c", "Quit")),
+	FilterProject: key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "Switch project filter")),
+}
+
+var mergeAutomaticallyKeys = []string{"m"}
+
+// defaults are restored before keys from config are applied, so that keys removed from config are reset
+var (
+	defaultKeys                   = Keys
+	defaultMergeAutomaticallyKeys = mergeAutomaticallyKeys
+)
+
+// bindings lists actions which can be rebound, up and down move through tables with their own keys, so they are left out.
+func bindings() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"left":           &Keys.Left,
+		"right":          &Keys.Right,
+		"quit":           &Keys.Quit,
+		"filter_project": &Keys.FilterProject,
+	}
+}
+
+// CheckActions tells if keys can be bound to given actions without changing any binding.
+func CheckActions(actions map[string][]string) error {
+	bindings := bindings()
+	for action, keys := range actions {
+		if len(keys) == 0 {
+			return fmt.Errorf("no key given for action %v", action)
+		}
+		if _, exists := bindings[action]; !exists && action != "merge_automatically" {
+			known := []string{"merge_automatically"}
+			for name := range bindings {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown action %v, known actions: %v", action, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// Rebind replaces keys of actions, i.e. quit or filter_project, with keys given in config, other actions get default keys.
+func Rebind(actions map[string][]string) error {
+	err := CheckActions(actions)
+	if err != nil {
+		return err
+	}
+	Keys = defaultKeys
+	mergeAutomaticallyKeys = defaultMergeAutomaticallyKeys
+	bindings := bindings()
+	for action, keys := range actions {
+		if action == "merge_automatically" {
+			mergeAutomaticallyKeys = keys
+			continue
+		}
+		binding := bindings[action]
+		binding.SetKeys(keys...)
+		binding.SetHelp(helpKeys(keys), binding.Help().Desc)
+	}
+	return nil
+}
+
+// CheckFavourites fails when key of a favourite branch is bound to merge_automatically, filter_project or quit, either
+// by actions config or by default.
+func CheckFavourites(favourites []Favourite, actions map[string][]string) error {
+	taken := make(map[string]string)
+	for action, defaults := range map[string][]string{
+		"merge_automatically": defaultMergeAutomaticallyKeys,
+		"filter_project":      defaultKeys.FilterProject.Keys(),
+		"quit":                defaultKeys.Quit.Keys(),
+	} {
+		keys, configured := actions[action]
+		if !configured {
+			keys = defaults
+		}
+		for _, k := range keys {
+			taken[k] = action
+		}
+	}
+	for i, favourite := range favourites {
+		if action, exists := taken[favourite.key(i)]; exists && favourite.Branch != "" {
+			return fmt.Errorf("key %v of favorite branch %v is already used by %v", favourite.key(i), favourite.Branch, action)
+		}
+	}
+	return nil
+}
+
+func helpKeys(keys []string) string {
+	return strings.Join(keys, "/")
 }
@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"log"
+	"time"
+)
+
+// Job is executed in background, Run returns message passed to all tabs and delay of the next execution.
+type Job struct {
+	Name string
+	Run  func() (tea.Msg, time.Duration)
+}
+
+type TriggerMessage struct {
+	Job string
+}
+
+type tickMessage struct {
+	job        string
+	generation int
+}
+
+type doneMessage struct {
+	job    string
+	result tea.Msg
+	next   time.Duration
+}
+
+type scheduledJob struct {
+	Job
+	running bool
+	// generation is increased every time job is started, ticks from older generations are ignored
+	generation int
+}
+
+// Scheduler owns all background jobs and guarantees that every job has at most one execution in progress and one pending tick.
+type Scheduler struct {
+	jobs  map[string]*scheduledJob
+	order []string
+}
+
+func New(jobs ...Job) *Scheduler {
+	scheduler := &Scheduler{
+		jobs: make(map[string]*scheduledJob),
+	}
+	for _, job := range jobs {
+		scheduler.jobs[job.Name] = &scheduledJob{Job: job}
+		scheduler.order = append(scheduler.order, job.Name)
+	}
+	return scheduler
+}
+
+// Trigger requests immediate execution of a job, it can be returned from any component.
+func Trigger(job string) tea.Cmd {
+	return func() tea.Msg {
+		return TriggerMessage{Job: job}
+	}
+}
+
+func (s *Scheduler) Init() tea.Cmd {
+	var cmds []tea.Cmd
+	for _, name := range s.order {
+		cmds = append(cmds, s.start(s.jobs[name]))
+	}
+	return tea.Batch(cmds...)
+}
+
+func (s *Scheduler) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case TriggerMessage:
+		job, exists := s.jobs[msg.Job]
+		if !exists {
+			log.Printf("Unknown background job %v", msg.Job)
+			return nil
+		}
+		return s.start(job)
+	case tickMessage:
+		job := s.jobs[msg.job]
+		if msg.generation != job.generation {
+			return nil
+		}
+		return s.start(job)
+	case doneMessage:
+		job := s.jobs[msg.job]
+		job.running = false
+		generation := job.generation
+		result := msg.result
+		return tea.Batch(
+			func() tea.Msg { return result },
+			tea.Tick(msg.next, func(time.Time) tea.Msg {
+				return tickMessage{job: job.Name, generation: generation}
+			}),
+		)
+	}
+	return nil
+}
+
+func (s *Scheduler) start(job *scheduledJob) tea.Cmd {
+	if job.running {
+		return nil
+	}
+	job.running = true
+	job.generation++
+	run := job.Run
+	name := job.Name
+	return func() tea.Msg {
+		result, next := run()
+		return doneMessage{job: name, result: result, next: next}
+	}
+}
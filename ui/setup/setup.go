@@ -0,0 +1,350 @@
+package setup
+
+import (
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/aprokopczyk/mergemate/ui/styles"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const defaultGitlabUrl = "https://gitlab.com"
+const maxSuggestions = 5
+
+type step int
+
+const (
+	stepUrl step = iota
+	stepToken
+	stepProject
+	stepBranchPrefix
+	stepConfirm
+)
+
+// Result holds values entered in the wizard.
+type Result struct {
+	GitlabUrl     string
+	ApiToken      string
+	UserName      string
+	ProjectName   string
+	BranchPrefix  string
+	DefaultBranch string
+}
+
+// Wizard asks for values required to start mergemate and checks them against gitlab.
+type Wizard struct {
+	step        step
+	input       textinput.Model
+	result      Result
+	user        *gitlab.User
+	client      *gitlab.ApiClient
+	projects    []gitlab.Project
+	suggestions []string
+	selected    int
+	// chosen is set when suggestion was selected with arrows, typed value is used otherwise if it's a known project
+	chosen    bool
+	busy      string
+	err       error
+	path      string
+	exists    bool
+	transport *gitlab.Transport
+	theme     styles.Theme
+	// Done is set when all values were entered and confirmed
+	Done bool
+}
+
+type userChecked struct {
+	user *gitlab.User
+	err  error
+}
+
+type projectsLoaded struct {
+	projects []gitlab.Project
+	err      error
+}
+
+type branchesLoaded struct {
+	branches []gitlab.Branch
+	err      error
+}
+
+// New creates wizard writing config file to path, exists warns that entries of existing file will be replaced. Transport configures
+// connection to gitlab, i.e. CA bundle or proxy.
+func New(path string, exists bool, transport *gitlab.Transport) *Wizard {
+	wizard := &Wizard{path: path, exists: exists, transport: transport, theme: styles.DefaultTheme()}
+	wizard.input = textinput.New()
+	wizard.input.Focus()
+	wizard.input.SetValue(defaultGitlabUrl)
+	return wizard
+}
+
+func (w *Wizard) Result() Result {
+	return w.result
+}
+
+func (w *Wizard) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (w *Wizard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.Type {
+		case tea.KeyCtrlC, tea.KeyEsc:
+			return w, tea.Quit
+		case tea.KeyEnter:
+			if w.busy != "" {
+				return w, nil
+			}
+			return w, w.submit()
+		case tea.KeyUp:
+			w.moveSelection(-1)
+			return w, nil
+		case tea.KeyDown, tea.KeyTab:
+			w.moveSelection(1)
+			return w, nil
+		}
+	case userChecked:
+		w.busy = ""
+		if msg.err != nil {
+			w.err = msg.err
+			return w, nil
+		}
+		w.user = msg.user
+		w.result.UserName = msg.user.Username
+		w.next(stepProject, "")
+		w.busy = "loading your projects"
+		return w, w.loadProjects
+	case projectsLoaded:
+		w.busy = ""
+		// projects are only suggested, name can be typed in when they couldn't be loaded
+		w.err = msg.err
+		w.projects = msg.projects
+		w.updateSuggestions()
+		return w, nil
+	case branchesLoaded:
+		w.busy = ""
+		w.err = msg.err
+		w.suggestions = prefixSuggestions(msg.branches, w.user)
+		if len(w.suggestions) > 0 {
+			w.input.SetValue(w.suggestions[0])
+			w.input.CursorEnd()
+		}
+		return w, nil
+	}
+
+	var cmd tea.Cmd
+	previous := w.input.Value()
+	w.input, cmd = w.input.Update(msg)
+	if w.step == stepProject && previous != w.input.Value() {
+		w.updateSuggestions()
+	}
+	return w, cmd
+}
+
+func (w *Wizard) submit() tea.Cmd {
+	value := strings.TrimSpace(w.input.Value())
+	w.err = nil
+	switch w.step {
+	case stepUrl:
+		parsed, err := url.Parse(value)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			w.err = fmt.Errorf("'%v' is not a valid http or https URL", value)
+			return nil
+		}
+		w.result.GitlabUrl = strings.TrimSuffix(value, "/")
+		w.next(stepToken, "")
+		w.input.EchoMode = textinput.EchoPassword
+	case stepToken:
+		if value == "" {
+			w.err = fmt.Errorf("token is required")
+			return nil
+		}
+		w.result.ApiToken = value
+		w.client = gitlab.New(w.result.GitlabUrl, "", "", value)
+		w.client.UseTransport(w.transport)
+		w.busy = "checking token"
+		client := w.client
+		return func() tea.Msg {
+			user, err := client.CurrentUser()
+			return userChecked{user: user, err: err}
+		}
+	case stepProject:
+		if w.selected < len(w.suggestions) && (w.chosen || !w.knownProject(value)) {
+			value = w.suggestions[w.selected]
+		}
+		if value == "" {
+			w.err = fmt.Errorf("project is required")
+			return nil
+		}
+		w.result.ProjectName = value
+		for _, project := range w.projects {
+			if project.PathWithNamespace == value {
+				w.result.DefaultBranch = project.DefaultBranch
+			}
+		}
+		w.next(stepBranchPrefix, "")
+		w.busy = "looking for your branches"
+		client := gitlab.New(w.result.GitlabUrl, value, "", w.result.ApiToken)
+		client.UseTransport(w.transport)
+		return func() tea.Msg {
+			branches, err := client.FetchBranchesWithPattern([]string{""})
+			return branchesLoaded{branches: branches, err: err}
+		}
+	case stepBranchPrefix:
+		if value == "" {
+			w.err = fmt.Errorf("branch prefix is required")
+			return nil
+		}
+		w.result.BranchPrefix = value
+		w.next(stepConfirm, "")
+	case stepConfirm:
+		w.Done = true
+		return tea.Quit
+	}
+	return nil
+}
+
+func (w *Wizard) next(step step, value string) {
+	w.step = step
+	w.suggestions = nil
+	w.selected = 0
+	w.chosen = false
+	w.input.EchoMode = textinput.EchoNormal
+	w.input.SetValue(value)
+}
+
+func (w *Wizard) loadProjects() tea.Msg {
+	projects, err := w.client.MemberProjects()
+	return projectsLoaded{projects: projects, err: err}
+}
+
+func (w *Wizard) moveSelection(delta int) {
+	if len(w.suggestions) == 0 {
+		return
+	}
+	w.selected = (w.selected + delta + len(w.suggestions)) % len(w.suggestions)
+	w.chosen = true
+	if w.step == stepBranchPrefix {
+		w.input.SetValue(w.suggestions[w.selected])
+		w.input.CursorEnd()
+	}
+}
+
+// updateSuggestions shows projects which contain typed text in their path.
+func (w *Wizard) updateSuggestions() {
+	typed := strings.ToLower(strings.TrimSpace(w.input.Value()))
+	w.suggestions = nil
+	w.selected = 0
+	w.chosen = false
+	for _, project := range w.projects {
+		if strings.Contains(strings.ToLower(project.PathWithNamespace), typed) {
+			w.suggestions = append(w.suggestions, project.PathWithNamespace)
+		}
+		if len(w.suggestions) == maxSuggestions {
+			break
+		}
+	}
+}
+
+func (w *Wizard) knownProject(path string) bool {
+	for _, project := range w.projects {
+		if project.PathWithNamespace == path {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixSuggestions proposes prefixes of branches authored by the user, the most used first, prefixes made of
+// user name are proposed as well.
+func prefixSuggestions(branches []gitlab.Branch, user *gitlab.User) []string {
+	counts := make(map[string]int)
+	for _, branch := range branches {
+		if !authoredBy(branch, user) {
+			continue
+		}
+		end := strings.IndexAny(branch.Name, "/_-")
+		if end > 0 {
+			counts[branch.Name[:end+1]]++
+		}
+	}
+	var suggestions []string
+	for prefix := range counts {
+		suggestions = append(suggestions, prefix)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if counts[suggestions[i]] == counts[suggestions[j]] {
+			return suggestions[i] < suggestions[j]
+		}
+		return counts[suggestions[i]] > counts[suggestions[j]]
+	})
+	if user != nil {
+		for _, prefix := range []string{user.Username + "/", user.Username + "_"} {
+			if counts[prefix] == 0 {
+				suggestions = append(suggestions, prefix)
+			}
+		}
+	}
+	return suggestions
+}
+
+func authoredBy(branch gitlab.Branch, user *gitlab.User) bool {
+	if user == nil {
+		return false
+	}
+	author := branch.Commit
+	return (author.AuthorEmail != "" && (author.AuthorEmail == user.Email || author.AuthorEmail == user.CommitEmail)) ||
+		(author.AuthorName != "" && author.AuthorName == user.Name)
+}
+
+func (w *Wizard) View() string {
+	title := lipgloss.NewStyle().Bold(true).Foreground(w.theme.Primary)
+	hint := lipgloss.NewStyle().Faint(true)
+	warning := lipgloss.NewStyle().Foreground(w.theme.Warning)
+	view := strings.Builder{}
+	view.WriteString(title.Render("mergemate setup") + "\n\n")
+	switch w.step {
+	case stepUrl:
+		view.WriteString("Gitlab URL\n" + w.input.View() + "\n")
+	case stepToken:
+		view.WriteString("Personal access token with api scope\n" + w.input.View() + "\n")
+	case stepProject:
+		view.WriteString(fmt.Sprintf("Logged in as %v.\n\nProject\n%v\n", w.result.UserName, w.input.View()))
+	case stepBranchPrefix:
+		view.WriteString("Prefix of your branches\n" + w.input.View() + "\n")
+	case stepConfirm:
+		view.WriteString(fmt.Sprintf("Gitlab URL:     %v\nUser:           %v\nProject:        %v\nBranch prefix:  %v\n",
+			w.result.GitlabUrl, w.result.UserName, w.result.ProjectName, w.result.BranchPrefix))
+		if w.result.DefaultBranch != "" {
+			view.WriteString(fmt.Sprintf("Target branch:  %v\n", w.result.DefaultBranch))
+		}
+		view.WriteString(fmt.Sprintf("\nConfig will be written to %v\n", w.path))
+		if w.exists {
+			view.WriteString(warning.Render("Entries above replace the ones in existing config file, other entries are kept.") + "\n")
+		}
+	}
+	selected := lipgloss.NewStyle().Foreground(w.theme.Primary)
+	for i, suggestion := range w.suggestions {
+		if i == w.selected {
+			suggestion = selected.Render(suggestion)
+		}
+		view.WriteString("  " + suggestion + "\n")
+	}
+	if w.busy != "" {
+		view.WriteString("\n" + hint.Render(w.busy+"...") + "\n")
+	}
+	if w.err != nil {
+		view.WriteString("\n" + warning.Render(w.err.Error()) + "\n")
+	}
+	help := "enter confirm • ↑/↓ choose suggestion • esc quit"
+	if w.step == stepConfirm {
+		help = "enter write config • esc quit without writing"
+	}
+	view.WriteString("\n" + hint.Render(help) + "\n")
+	return view.String()
+}
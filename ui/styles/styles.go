@@ -12,37 +12,58 @@ const (
 	MinTablePageSize  = 5
 )
 
+// Theme holds colors of the whole user interface.
+type Theme struct {
+	Primary   lipgloss.Color
+	Secondary lipgloss.Color
+	Warning   lipgloss.Color
+}
+
+func DefaultTheme() Theme {
+	return Theme{
+		Primary:   colors.Emerald600,
+		Secondary: colors.Emerald800,
+		Warning:   colors.Amber500,
+	}
+}
+
 type Styles struct {
-	Tabs struct {
-		TabItem lipgloss.Style
-		Header  lipgloss.Style
-		Content lipgloss.Style
+	Theme Theme
+	Tabs  struct {
+		TabItem          lipgloss.Style
+		Header           lipgloss.Style
+		Content          lipgloss.Style
+		ConnectionStatus lipgloss.Style
 	}
 	Help      lipgloss.Style
 	ActionLog lipgloss.Style
 }
 
-func NewStyles() Styles {
+func NewStyles(theme Theme) Styles {
 	var styles Styles
+	styles.Theme = theme
 
 	styles.Tabs.TabItem = lipgloss.NewStyle().
 		Border(lipgloss.NormalBorder(), false, true, false, false).
 		Padding(0, 1, 0, 1).
-		BorderForeground(colors.Emerald800)
+		BorderForeground(theme.Secondary)
 	styles.Tabs.Header = lipgloss.NewStyle().
 		Border(lipgloss.ThickBorder(), false, false, true, false).
 		Padding(1, 0, 0, 2).
-		BorderForeground(colors.Emerald800)
+		BorderForeground(theme.Secondary)
 	styles.Tabs.Content = lipgloss.NewStyle().Padding(0, 0, 0, 2)
+	styles.Tabs.ConnectionStatus = lipgloss.NewStyle().
+		Padding(0, 1, 0, 2).
+		Foreground(theme.Primary)
 
 	styles.Help = lipgloss.NewStyle().
 		Border(lipgloss.ThickBorder(), true, false, false, false).
-		BorderForeground(colors.Emerald800)
+		BorderForeground(theme.Secondary)
 
 	styles.ActionLog = lipgloss.NewStyle().
 		Border(lipgloss.ThickBorder(), true, false, false, false).
 		Padding(0, 1, 0, 2).
-		BorderForeground(colors.Emerald800)
+		BorderForeground(theme.Secondary)
 
 	return styles
 }
@@ -3,8 +3,10 @@ package tabs
 import (
 	"container/ring"
 	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/offline"
 	"github.com/aprokopczyk/mergemate/ui/context"
 	tea "github.com/charmbracelet/bubbletea"
+	"strings"
 )
 
 const (
@@ -12,6 +14,10 @@ const (
 	headerHeight    = 2
 )
 
+type QueuedActionsReplayed struct {
+	Outcomes []offline.Outcome
+}
+
 type ActionMessage struct {
 	Content string
 	Success bool
@@ -31,6 +37,26 @@ func success(content string) ActionMessage {
 	}
 }
 
+func queued(content string) ActionMessage {
+	return ActionMessage{
+		Content: "Queued: " + content,
+		Success: true,
+	}
+}
+
+func restartRequired(content string) ActionMessage {
+	return ActionMessage{
+		Content: "Restart needed: " + content,
+		Success: false,
+	}
+}
+
+func actionMessage(message ActionMessage) tea.Cmd {
+	return func() tea.Msg {
+		return message
+	}
+}
+
 type ActionLog struct {
 	buffer  *ring.Ring
 	context *context.AppContext
@@ -53,11 +79,35 @@ func (model ActionLog) Update(msg tea.Msg) (*ActionLog, tea.Cmd) {
 	switch msg := msg.(type) {
 	case MergeRequestCreated:
 		mergeRequest := msg.mergeRequest
-		model.buffer.Value = success(fmt.Sprintf("Created merge request: '%s' from branch %s", mergeRequest.Title, mergeRequest.SourceBranch, mergeRequest.Iid))
+		model.buffer.Value = success(fmt.Sprintf("Created merge request: '%s' from branch %s in %s", mergeRequest.Title, mergeRequest.SourceBranch, msg.project))
 		model.buffer = model.buffer.Next()
 	case ActionMessage:
 		model.buffer.Value = msg
 		model.buffer = model.buffer.Next()
+	case context.ReloadedConfigMessage:
+		var messages []ActionMessage
+		if msg.Err != nil {
+			messages = append(messages, failed(fmt.Sprintf("changed config wasn't applied: %v", msg.Err)))
+		}
+		if len(msg.Applied) > 0 {
+			messages = append(messages, success(fmt.Sprintf("applied changed config: %v", strings.Join(msg.Applied, ", "))))
+		}
+		if len(msg.RestartRequired) > 0 {
+			messages = append(messages, restartRequired(fmt.Sprintf("changed config will be applied after restart: %v", strings.Join(msg.RestartRequired, ", "))))
+		}
+		for _, message := range messages {
+			model.buffer.Value = message
+			model.buffer = model.buffer.Next()
+		}
+	case QueuedActionsReplayed:
+		for _, outcome := range msg.Outcomes {
+			if outcome.Err != nil {
+				model.buffer.Value = failed(fmt.Sprintf("queued action '%v' couldn't be done: %v", outcome.Action, outcome.Err))
+			} else {
+				model.buffer.Value = success(fmt.Sprintf("done queued action '%v'", outcome.Action))
+			}
+			model.buffer = model.buffer.Next()
+		}
 	}
 	return &model, nil
 }
@@ -1,15 +1,16 @@
 package tabs
 
 import (
+	"github.com/aprokopczyk/mergemate/pkg/engine"
 	"github.com/aprokopczyk/mergemate/pkg/gitlab"
-	"github.com/aprokopczyk/mergemate/ui/colors"
+	"github.com/aprokopczyk/mergemate/pkg/webhook"
 	"github.com/aprokopczyk/mergemate/ui/context"
+	"github.com/aprokopczyk/mergemate/ui/scheduler"
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/evertras/bubble-table/table"
 	"log"
-	"strings"
 	"time"
 )
 
@@ -26,13 +27,6 @@ const checking = "checking"
 const yes = "yes"
 const no = "no"
 
-const MergeAutomatically = "MERGE_AUTOMATICALLY"
-
-type MergeRequestWithMetadata struct {
-	mergeRequest         gitlab.MergeRequestDetails
-	automaticMergeStatus string
-}
-
 type RequestMetadata struct {
 	mergeAutomatically string
 	status             string
@@ -40,14 +34,15 @@ type RequestMetadata struct {
 
 type ActiveMergeRequestTable struct {
 	flexTable     table.Model
-	mrMetadata    map[int]RequestMetadata
-	mergeRequests []gitlab.MergeRequestDetails
+	mrMetadata    map[mergeRequestKey]RequestMetadata
+	mergeRequests []projectMergeRequest
 	context       *context.AppContext
 }
 
 func NewActiveMergeRequestTable(context *context.AppContext) *ActiveMergeRequestTable {
 	return &ActiveMergeRequestTable{
 		flexTable: table.New([]table.Column{
+			table.NewFlexColumn(columnKeyProject, "Project", 1),
 			table.NewFlexColumn(columnKeyMergeRequest, "Merge request", 1),
 			table.NewFlexColumn(columnKeyMergeAutomatically, "Merge automatically", 1),
 			table.NewFlexColumn(columnKeyStatus, "Status", 1),
@@ -55,131 +50,76 @@ func NewActiveMergeRequestTable(context *context.AppContext) *ActiveMergeRequest
 			table.NewFlexColumn(columnKeyTargetBranch, "Target branch", 1),
 		}).WithRows([]table.Row{}).Focused(true).
 			HeaderStyle(lipgloss.NewStyle().Bold(true)).
-			WithBaseStyle(lipgloss.NewStyle().Align(lipgloss.Left).BorderForeground(colors.Emerald600)).
+			WithBaseStyle(tableStyle(context)).
 			WithPageSize(context.TablePageSize),
 		context:    context,
-		mrMetadata: make(map[int]RequestMetadata),
+		mrMetadata: make(map[mergeRequestKey]RequestMetadata),
 	}
 }
 
-func (m *ActiveMergeRequestTable) listMergeRequests() tea.Msg {
-	mergeRequests, err := m.context.GitlabClient.OpenedMergeRequests()
-	if err != nil {
-		log.Printf("Error when fetching merge requests %v", err)
-	}
-	return mergeRequests
+// ActiveMergeRequests holds opened merge requests of every project, keyed by project name.
+type ActiveMergeRequests struct {
+	MergeRequests map[string][]gitlab.MergeRequestDetails
+	StaleSince    time.Time
 }
 
 type MergeAutomaticallyStatus struct {
-	mergeRequestIid             int
+	mergeRequest                mergeRequestKey
 	shouldBeMergedAutomatically bool
 }
 
-func (m *ActiveMergeRequestTable) shouldBeMergedAutomatically(mergeRequestIid int) tea.Cmd {
+func (m *ActiveMergeRequestTable) shouldBeMergedAutomatically(mergeRequest projectMergeRequest) tea.Cmd {
+	mergeEngine := m.context.Project(mergeRequest.project).MergeEngine
 	return func() tea.Msg {
-		notes, err := m.context.GitlabClient.ListMergeRequestNotes(mergeRequestIid)
+		shouldBeMerged, err := mergeEngine.ShouldBeMergedAutomatically(mergeRequest.MergeRequestDetails)
 		if err != nil {
+			// status stays unknown and is checked again with next refresh
 			log.Printf("Error when fetching merge request notes %v", err)
-		}
-		var shouldBeMergedAutomatically bool
-		for _, note := range notes {
-			if strings.HasPrefix(note.Body, MergeAutomatically) {
-				shouldBeMergedAutomatically = true
-				break
-			}
+			return nil
 		}
 		return MergeAutomaticallyStatus{
-			mergeRequestIid:             mergeRequestIid,
-			shouldBeMergedAutomatically: shouldBeMergedAutomatically,
+			mergeRequest:                mergeRequest.key(),
+			shouldBeMergedAutomatically: shouldBeMerged,
 		}
 	}
 }
 
+// MergeRequestProcessingResult holds results of merge job for every project, keyed by project name.
 type MergeRequestProcessingResult struct {
-	mrStatus map[int]string
+	Results map[string]engine.Result
 }
 
-const merged = "Merged"
-
-func (m *ActiveMergeRequestTable) processMergeRequests(mergeRequests map[int]bool) tea.Cmd {
-	return tea.Tick(time.Second*time.Duration(m.context.MergeJobInterval), func(t time.Time) tea.Msg {
-		log.Printf("Processing merge requests: %v", mergeRequests)
-		mrStatus := make(map[int]string)
-		var rebasing []int
+type MergeRequestEvaluationResult struct {
+	Project string
+	engine.Result
+}
 
-		for mergeRequestIid, shouldBeMerged := range mergeRequests {
-			mergeRequest, err := m.context.GitlabClient.GetMergeRequestDetails(mergeRequestIid)
-			if err != nil {
-				log.Printf("Fetching merge request details failed %v", err)
-				continue
-			}
-			if mergeRequest.RebaseInProgress {
-				log.Printf("Merge request {id = %v, title=%v} is being rebased.", mergeRequest.Iid, mergeRequest.Title)
-				mrStatus[mergeRequestIid] = "Rebase in progress"
-				continue
-			} else if mergeRequest.RebaseError != "" && mergeRequest.HasConflicts {
-				mrStatus[mergeRequestIid] = "Merge conflict"
-				continue
-			}
-			pipelines, err := m.context.GitlabClient.GetMergeRequestPipelines(mergeRequestIid)
-			if err != nil {
-				log.Printf("Error when fetching pipeline for merge request{id = %v, title=%v}: %v", mergeRequestIid, mergeRequest.Title, err)
-			}
-			if gitlab.IsPipelineRunning(pipelines) {
-				mrStatus[mergeRequestIid] = "CI running"
-				continue
-			}
-			if len(pipelines) > 0 && pipelines[0].Status == "failed" {
-				mrStatus[mergeRequestIid] = "CI failed"
-				continue
-			}
-			isBehindTargetBranch := mergeRequest.CommitsBehind > 0
-			if shouldBeMerged && isBehindTargetBranch {
-				log.Printf("Merge request {id = %v, title=%v} is behind target branch by %v commits, it will be rebased.", mergeRequestIid, mergeRequest.Title, mergeRequest.CommitsBehind)
-				// we will rebase outside loop, in case there is mr that could be merged, we'll need to rebase only once
-				rebasing = append(rebasing, mergeRequestIid)
-				continue
-			} else if isBehindTargetBranch {
-				mrStatus[mergeRequestIid] = "Needs rebase"
-				continue
-			}
-			automaticMergeAllowed := gitlab.IsAutomaticMergeAllowed(pipelines)
-			if shouldBeMerged && automaticMergeAllowed {
-				// hurray, we can merge it!
-				log.Printf("Merging merge request {id = %v, title=%v}.", mergeRequestIid, mergeRequest.Title)
-				// we pass sha to make sure that nothing was pushed in the meantime
-				request, err := m.context.GitlabClient.MergeMergeRequest(mergeRequestIid, mergeRequest.Sha)
-				if err != nil {
-					log.Printf("Error when merging merge request {id = %v, title=%v}: %v ", mergeRequestIid, mergeRequest.Title, err)
-					mrStatus[mergeRequestIid] = "Merge failed"
-					continue
-				}
-				if request.State == "merged" {
-					log.Printf("Merged merge request {id = %v, title=%v}.", mergeRequestIid, mergeRequest.Title)
-					mrStatus[mergeRequestIid] = merged
-				}
-				continue
-			} else if automaticMergeAllowed {
-				mrStatus[mergeRequestIid] = "Ready to merge"
-			}
-		}
+func (m *ActiveMergeRequestTable) evaluateMergeRequest(mergeRequest projectMergeRequest) tea.Cmd {
+	toBeMerged := map[int]bool{mergeRequest.Iid: m.mrMetadata[mergeRequest.key()].mergeAutomatically == yes}
+	mergeEngine := m.context.Project(mergeRequest.project).MergeEngine
+	return func() tea.Msg {
+		return MergeRequestEvaluationResult{Project: mergeRequest.project, Result: mergeEngine.Evaluate(toBeMerged)}
+	}
+}
 
-		for _, mrIid := range rebasing {
-			mrStatus[mrIid] = "Rebase in progress"
-			err := m.context.GitlabClient.RebaseMergeRequest(mrIid, true)
-			if err != nil {
-				log.Printf("Error when rebasing merge request {id = %v}: %v", mrIid, err)
-			}
+func (m *ActiveMergeRequestTable) onWebhookEvent(event webhook.Event) []tea.Cmd {
+	var cmds []tea.Cmd
+	if event.Kind == webhook.KindMergeRequest {
+		cmds = append(cmds, scheduler.Trigger(ActiveMergeRequestsJob))
+	}
+	for _, mergeRequest := range m.mergeRequests {
+		if mergeRequest.project != event.Project {
+			continue
 		}
-
-		return MergeRequestProcessingResult{
-			mrStatus: mrStatus,
+		if mergeRequest.Iid == event.MergeRequestIid || (event.MergeRequestIid == 0 && mergeRequest.SourceBranch == event.SourceBranch) {
+			cmds = append(cmds, m.evaluateMergeRequest(mergeRequest))
 		}
-	})
+	}
+	return cmds
 }
 
 func (m *ActiveMergeRequestTable) Init() tea.Cmd {
-	return tea.Batch(m.listMergeRequests, m.processMergeRequests(map[int]bool{}))
+	return nil
 }
 
 func (m *ActiveMergeRequestTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
@@ -192,67 +132,124 @@ func (m *ActiveMergeRequestTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
 	cmds = append(cmds, cmd)
 
 	switch msg := msg.(type) {
-	case []gitlab.MergeRequestDetails:
-		mergeAutomaticallyStatuses := make(map[int]RequestMetadata)
-		for i := 0; i < len(msg); i++ {
-			mrIid := msg[i].Iid
-			oldEntry, exists := m.mrMetadata[mrIid]
+	case ActiveMergeRequests:
+		mergeRequests := flattenMergeRequests(msg.MergeRequests)
+		previouslyUpdated := make(map[mergeRequestKey]time.Time)
+		for _, mergeRequest := range m.mergeRequests {
+			previouslyUpdated[mergeRequest.key()] = mergeRequest.UpdatedAt
+		}
+		mergeAutomaticallyStatuses := make(map[mergeRequestKey]RequestMetadata)
+		for _, mergeRequest := range mergeRequests {
+			mrKey := mergeRequest.key()
+			oldEntry, exists := m.mrMetadata[mrKey]
 			var mergeAutomaticallyStatus = RequestMetadata{
 				mergeAutomatically: checking,
 				status:             checking,
 			}
 			if exists {
 				mergeAutomaticallyStatus = oldEntry
-			} else {
-				cmds = append(cmds, m.shouldBeMergedAutomatically(mrIid))
 			}
-			mergeAutomaticallyStatuses[mrIid] = mergeAutomaticallyStatus
+			unknown := !exists || oldEntry.mergeAutomatically == checking
+			if msg.StaleSince.IsZero() && (unknown || !previouslyUpdated[mrKey].Equal(mergeRequest.UpdatedAt)) {
+				cmds = append(cmds, m.shouldBeMergedAutomatically(mergeRequest))
+			}
+			mergeAutomaticallyStatuses[mrKey] = mergeAutomaticallyStatus
 		}
 		m.mrMetadata = mergeAutomaticallyStatuses
-		m.mergeRequests = msg
+		m.mergeRequests = mergeRequests
+		m.trackMergeRequests()
 		m.redrawTable()
 		m.flexTable = m.flexTable.PageFirst()
 	case MergeRequestCreated:
-		cmds = append(cmds, m.listMergeRequests)
+		cmds = append(cmds, scheduler.Trigger(ActiveMergeRequestsJob))
+	case QueuedActionsReplayed:
+		cmds = append(cmds, scheduler.Trigger(ActiveMergeRequestsJob))
 	case MergeAutomaticallyStatus:
 		shouldBeMerged := no
 		if msg.shouldBeMergedAutomatically {
 			shouldBeMerged = yes
 		}
-		metadata, exists := m.mrMetadata[msg.mergeRequestIid]
+		metadata, exists := m.mrMetadata[msg.mergeRequest]
 		if exists {
 			metadata.mergeAutomatically = shouldBeMerged
-			m.mrMetadata[msg.mergeRequestIid] = metadata
+			m.mrMetadata[msg.mergeRequest] = metadata
 		}
+		m.trackMergeRequests()
 		m.redrawTable()
 	case MergeRequestProcessingResult:
-		for mrIid, status := range msg.mrStatus {
-			metadata, exists := m.mrMetadata[mrIid]
-			if exists {
-				metadata.status = status
-				m.mrMetadata[mrIid] = metadata
-			}
+		for project, result := range msg.Results {
+			cmds = append(cmds, m.applyResult(project, result)...)
 		}
-		var toBeMerged = make(map[int]bool)
-		for _, request := range m.mergeRequests {
-			toBeMerged[request.Iid] = m.mrMetadata[request.Iid].mergeAutomatically == yes
-		}
-		cmds = append(cmds, m.processMergeRequests(toBeMerged))
+		m.trackMergeRequests()
+		m.redrawTable()
+	case MergeRequestEvaluationResult:
+		cmds = append(cmds, m.applyResult(msg.Project, msg.Result)...)
+		m.trackMergeRequests()
 		m.redrawTable()
+	case webhook.Event:
+		cmds = append(cmds, m.onWebhookEvent(msg)...)
 	case context.UpdatedContextMessage:
 		m.recalculateTable()
+		m.redrawTable()
 	}
 
 	return m, tea.Batch(cmds...)
 }
 
+func (m *ActiveMergeRequestTable) applyResult(project string, result engine.Result) []tea.Cmd {
+	var cmds []tea.Cmd
+	for mrIid, status := range result.Status {
+		mrKey := mergeRequestKey{project: project, iid: mrIid}
+		metadata, exists := m.mrMetadata[mrKey]
+		if exists {
+			metadata.status = status
+			m.mrMetadata[mrKey] = metadata
+		}
+	}
+	for mrIid, shouldBeMerged := range result.MergeAutomatically {
+		mrKey := mergeRequestKey{project: project, iid: mrIid}
+		metadata, exists := m.mrMetadata[mrKey]
+		if exists {
+			metadata.mergeAutomatically = no
+			if shouldBeMerged {
+				metadata.mergeAutomatically = yes
+			}
+			m.mrMetadata[mrKey] = metadata
+		}
+	}
+	for _, action := range result.Actions {
+		cmds = append(cmds, actionMessage(success(action)))
+	}
+	return cmds
+}
+
+// trackMergeRequests hands listed merge requests over to merge engines of their projects.
+func (m *ActiveMergeRequestTable) trackMergeRequests() {
+	toBeMerged := make(map[string]map[int]bool)
+	for _, project := range m.context.Projects() {
+		toBeMerged[project.Name] = make(map[int]bool)
+	}
+	for _, request := range m.mergeRequests {
+		if _, exists := toBeMerged[request.project]; exists {
+			toBeMerged[request.project][request.Iid] = m.mrMetadata[request.key()].mergeAutomatically == yes
+		}
+	}
+	for _, project := range m.context.Projects() {
+		project.MergeEngine.Track(toBeMerged[project.Name])
+	}
+}
+
 func (m *ActiveMergeRequestTable) redrawTable() {
 	var rows []table.Row
 	for _, mergeRequest := range m.mergeRequests {
+		if !m.context.IsShown(mergeRequest.project) {
+			continue
+		}
 		rows = append(rows, table.NewRow(table.RowData{
+			columnKeyProject:              mergeRequest.project,
 			columnKeyMergeRequest:         mergeRequest.Title,
-			columnKeyMergeAutomatically:   m.mrMetadata[mergeRequest.Iid].mergeAutomatically,
-			columnKeyStatus:               m.mrMetadata[mergeRequest.Iid].status,
+			columnKeyMergeAutomatically:   m.mrMetadata[mergeRequest.key()].mergeAutomatically,
+			columnKeyStatus:               m.mrMetadata[mergeRequest.key()].status,
 			columnKeySourceBranch:         mergeRequest.SourceBranch,
 			columnKeyTargetBranch:         mergeRequest.TargetBranch,
 			columnKeyMergeRequestMetadata: mergeRequest,
@@ -260,10 +257,10 @@ func (m *ActiveMergeRequestTable) redrawTable() {
 	}
 	m.flexTable = m.flexTable.WithRows(rows)
 }
-
 func (m *ActiveMergeRequestTable) recalculateTable() {
 	m.flexTable = m.flexTable.WithTargetWidth(m.context.WindowWidth - m.context.Styles.Tabs.Content.GetHorizontalFrameSize())
 	m.flexTable = m.flexTable.WithPageSize(m.context.TablePageSize)
+	m.flexTable = m.flexTable.WithBaseStyle(tableStyle(m.context))
 }
 
 func (m *ActiveMergeRequestTable) FullHelp() []key.Binding {
@@ -3,8 +3,9 @@ package tabs
 import (
 	"errors"
 	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/engine"
 	"github.com/aprokopczyk/mergemate/pkg/gitlab"
-	"github.com/aprokopczyk/mergemate/ui/colors"
+	"github.com/aprokopczyk/mergemate/pkg/offline"
 	"github.com/aprokopczyk/mergemate/ui/context"
 	"github.com/aprokopczyk/mergemate/ui/keys"
 	"github.com/charmbracelet/bubbles/help"
@@ -14,7 +15,6 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/evertras/bubble-table/table"
 	"log"
-	"net"
 	"time"
 )
 
@@ -32,6 +32,10 @@ type BranchTable struct {
 	keys             keys.BranchKeyMap
 	context          *context.AppContext
 	showMergeTargets bool
+	branches         []projectBranch
+	targetBranches   map[string][]gitlab.Branch
+	// project of the highlighted branch, its favourite and target branches are offered
+	project string
 }
 
 type branchItem struct {
@@ -39,6 +43,7 @@ type branchItem struct {
 }
 
 type MergeRequestCreated struct {
+	project      string
 	mergeRequest gitlab.MergeRequestDetails
 }
 
@@ -49,19 +54,28 @@ func (i branchItem) FilterValue() string { return i.name }
 func NewBranchTable(context *context.AppContext) *BranchTable {
 	helpModel := help.New()
 	helpModel.ShowAll = true
+	// with only groups configured projects are known once their merge requests are listed
+	var project string
+	var favouriteBranches []keys.Favourite
+	if projects := context.Projects(); len(projects) > 0 {
+		project = projects[0].Name
+		favouriteBranches = projects[0].FavouriteBranches
+	}
 	return &BranchTable{
 		flexTable: table.New([]table.Column{
+			table.NewFlexColumn(columnKeyProject, "Project", 10),
 			table.NewFlexColumn(columnKeyBranchName, "Branch", 15),
 			table.NewFlexColumn(columnKeyLastCommit, "Last commit date", 15),
 		}).WithRows([]table.Row{}).
 			Focused(true).
 			HeaderStyle(lipgloss.NewStyle().Bold(true)).
-			WithBaseStyle(lipgloss.NewStyle().Align(lipgloss.Left).BorderForeground(colors.Emerald600)).
+			WithBaseStyle(tableStyle(context)).
 			WithPageSize(context.TablePageSize),
 		branchesList:     createList(),
-		keys:             keys.BranchHelp(context.FavouriteBranches),
+		keys:             keys.BranchHelp(favouriteBranches),
 		context:          context,
 		showMergeTargets: false,
+		project:          project,
 	}
 }
 
@@ -76,45 +90,62 @@ func createList() list.Model {
 	return model
 }
 
+// UserBranches holds branches of every project, keyed by project name.
 type UserBranches struct {
-	branches []gitlab.Branch
+	Branches   map[string][]gitlab.Branch
+	StaleSince time.Time
 }
 
+// TargetBranches holds branches of every project, keyed by project name.
 type TargetBranches struct {
-	Branches []gitlab.Branch
+	Branches   map[string][]gitlab.Branch
+	StaleSince time.Time
 }
 
-func (m *BranchTable) listUsersBranches() tea.Msg {
-	branches := m.context.GitlabClient.FetchBranchesWithPattern([]string{m.context.UserBranchPrefix})
-
-	return UserBranches{branches}
-}
-
-func (m *BranchTable) createMergeRequest(sourceBranch string, targetBranch string, title string) tea.Cmd {
+func (m *BranchTable) createMergeRequest(project string, sourceBranch string, targetBranch string, title string) tea.Cmd {
+	client := m.context.Project(project).GitlabClient
 	return func() tea.Msg {
-		mergeRequest, err := m.context.GitlabClient.CreateMergeRequest(sourceBranch, targetBranch, title)
+		mergeRequest, err := client.CreateMergeRequest(sourceBranch, targetBranch, title)
 
 		if errors.Is(err, gitlab.MergeRequestAlreadyExists) {
 			return failed(fmt.Sprintf("merge request from branch %v already exists", sourceBranch))
-		} else if errors.As(err, new(net.Error)) {
-			return failed("merge request creation failed, please check your network connection")
+		} else if offline.IsOffline(err) {
+			return m.queueAction(offline.Action{
+				Kind:         offline.ActionCreateMergeRequest,
+				Project:      project,
+				SourceBranch: sourceBranch,
+				TargetBranch: targetBranch,
+				Title:        title,
+			})
 		} else if err != nil {
 			log.Printf("Error when creating merge request %v", err)
 			return failed("unrecognized error when creating merge request, please check log file")
 		}
-		err = m.context.GitlabClient.CreateMergeRequestNote(mergeRequest.Iid, MergeAutomatically)
-		if err != nil {
+		_, err = client.CreateMergeRequestNote(mergeRequest.Iid, engine.MergeAutomatically)
+		if offline.IsOffline(err) {
+			return m.queueAction(offline.Action{Kind: offline.ActionMergeAutomatically, Project: project, MergeRequestIid: mergeRequest.Iid})
+		} else if err != nil {
 			log.Printf("Error when marking merge request to be merged automatically %v", err)
 			return nil
 		}
 		return MergeRequestCreated{
+			project:      project,
 			mergeRequest: *mergeRequest,
 		}
 	}
 }
 
+func (m *BranchTable) queueAction(action offline.Action) tea.Msg {
+	err := m.context.ActionQueue.Add(action)
+	if err != nil {
+		log.Printf("Error when queueing action %v", err)
+		return failed(fmt.Sprintf("gitlab is not reachable and action '%v' couldn't be queued", action))
+	}
+	return queued(fmt.Sprintf("gitlab is not reachable, '%v' will be done once connection is back", action))
+}
+
 func (m *BranchTable) Init() tea.Cmd {
-	return tea.Batch(m.listUsersBranches)
+	return nil
 }
 
 func (m *BranchTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
@@ -125,33 +156,16 @@ func (m *BranchTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case UserBranches:
-		var rows []table.Row
-		branches := msg.branches
-		for i := 0; i < len(branches); i++ {
-			branch := branches[i]
-			rows = append(rows, table.NewRow(table.RowData{
-				columnKeyBranchName:     branch.Name,
-				columnKeyLastCommit:     branch.Commit.AuthoredDate.In(time.Local).Format(lasCommitFormat),
-				columnKeyBranchMetadata: branch,
-			}))
-
-		}
-		m.flexTable = m.flexTable.WithRows(rows)
+		m.branches = flattenBranches(msg.Branches)
+		m.redrawTable()
 		m.flexTable = m.flexTable.PageFirst()
 	case TargetBranches:
-		var targetBranches []list.Item
-		branches := msg.Branches
-		for i := 0; i < len(branches); i++ {
-			branch := branches[i]
-			item := branchItem{name: branch.Name}
-			if branch.Default {
-				targetBranches = append([]list.Item{item}, targetBranches...)
-			} else {
-				targetBranches = append(targetBranches, item)
-			}
-		}
-		m.branchesList.SetItems(targetBranches)
+		m.targetBranches = msg.Branches
+		m.updateTargetBranches()
 	case context.UpdatedContextMessage:
+		// favourites and keys can be changed by reloaded config
+		m.rebindKeys()
+		m.redrawTable()
 		m.recalculateComponents()
 	case tea.KeyMsg:
 		switch {
@@ -164,17 +178,18 @@ func (m *BranchTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
 				m.changeBranchSelectionVisibility(false)
 			}
 		case key.Matches(msg, m.keys.SelectTargetBranch):
-			if m.showMergeTargets && m.branchesList.FilterState() != list.Filtering {
-				sourceBranch := m.flexTable.HighlightedRow().Data[columnKeyBranchMetadata].(gitlab.Branch)
+			sourceBranch, highlighted := m.highlightedBranch()
+			if m.showMergeTargets && m.branchesList.FilterState() != list.Filtering && highlighted {
 				targetBranch := m.branchesList.SelectedItem().(branchItem)
-				cmds = append(cmds, m.createMergeRequest(sourceBranch.Name, targetBranch.name, sourceBranch.Commit.Message))
+				cmds = append(cmds, m.createMergeRequest(sourceBranch.project, sourceBranch.Name, targetBranch.name, sourceBranch.Commit.Message))
 				m.changeBranchSelectionVisibility(false)
 			}
 		default:
 			for i, binding := range m.keys.MergeFavourite {
-				if key.Matches(msg, binding) && !m.showMergeTargets {
-					sourceBranch := m.flexTable.HighlightedRow().Data[columnKeyBranchMetadata].(gitlab.Branch)
-					cmds = append(cmds, m.createMergeRequest(sourceBranch.Name, m.context.FavouriteBranches[i], sourceBranch.Commit.Message))
+				sourceBranch, highlighted := m.highlightedBranch()
+				if key.Matches(msg, binding) && !m.showMergeTargets && highlighted {
+					favourite := m.keys.MergeFavouriteTargets[i]
+					cmds = append(cmds, m.createMergeRequest(sourceBranch.project, sourceBranch.Name, favourite, sourceBranch.Commit.Message))
 				}
 			}
 		}
@@ -183,6 +198,7 @@ func (m *BranchTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
 	if !m.showMergeTargets {
 		m.flexTable, cmd = m.flexTable.Update(msg)
 		cmds = append(cmds, cmd)
+		m.followHighlightedProject()
 	} else {
 		m.branchesList, cmd = m.branchesList.Update(msg)
 		cmds = append(cmds, cmd)
@@ -191,6 +207,71 @@ func (m *BranchTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+func (m *BranchTable) redrawTable() {
+	var rows []table.Row
+	for _, branch := range m.branches {
+		if !m.context.IsShown(branch.project) {
+			continue
+		}
+		rows = append(rows, table.NewRow(table.RowData{
+			columnKeyProject:        branch.project,
+			columnKeyBranchName:     branch.Name,
+			columnKeyLastCommit:     branch.Commit.AuthoredDate.In(time.Local).Format(lasCommitFormat),
+			columnKeyBranchMetadata: branch,
+		}))
+	}
+	m.flexTable = m.flexTable.WithRows(rows)
+	m.followHighlightedProject()
+}
+
+func (m *BranchTable) highlightedBranch() (projectBranch, bool) {
+	branch, highlighted := m.flexTable.HighlightedRow().Data[columnKeyBranchMetadata].(projectBranch)
+	return branch, highlighted
+}
+
+func (m *BranchTable) favouriteBranches() []keys.Favourite {
+	project := m.context.Project(m.project)
+	if project == nil {
+		return nil
+	}
+	return project.FavouriteBranches
+}
+
+// followHighlightedProject offers favourite and target branches of the project of highlighted branch.
+func (m *BranchTable) followHighlightedProject() {
+	branch, highlighted := m.highlightedBranch()
+	if !highlighted || branch.project == m.project {
+		return
+	}
+	m.project = branch.project
+	m.rebindKeys()
+	m.updateTargetBranches()
+}
+
+// rebindKeys offers favourite branches of the project of highlighted branch, keys are enabled according to visibility of target branches.
+func (m *BranchTable) rebindKeys() {
+	m.keys = keys.BranchHelp(m.favouriteBranches())
+	m.keys.CloseTargetBranchesList.SetEnabled(m.showMergeTargets)
+	m.keys.SelectTargetBranch.SetEnabled(m.showMergeTargets)
+	m.keys.MergeAutomatically.SetEnabled(!m.showMergeTargets)
+	for i := range m.keys.MergeFavourite {
+		m.keys.MergeFavourite[i].SetEnabled(!m.showMergeTargets)
+	}
+}
+
+func (m *BranchTable) updateTargetBranches() {
+	var targetBranches []list.Item
+	for _, branch := range m.targetBranches[m.project] {
+		item := branchItem{name: branch.Name}
+		if branch.Default {
+			targetBranches = append([]list.Item{item}, targetBranches...)
+		} else {
+			targetBranches = append(targetBranches, item)
+		}
+	}
+	m.branchesList.SetItems(targetBranches)
+}
+
 func (m *BranchTable) changeBranchSelectionVisibility(visible bool) {
 	m.keys.CloseTargetBranchesList.SetEnabled(visible)
 	m.keys.SelectTargetBranch.SetEnabled(visible)
@@ -211,6 +292,7 @@ func (m *BranchTable) recalculateComponents() {
 	m.branchesList.SetWidth(v)
 	m.branchesList.SetHeight(m.context.TableContentHeight)
 	m.flexTable = m.flexTable.WithPageSize(m.context.TablePageSize)
+	m.flexTable = m.flexTable.WithBaseStyle(tableStyle(m.context))
 }
 
 func (m *BranchTable) tableSize() int {
@@ -2,66 +2,45 @@ package tabs
 
 import (
 	"github.com/aprokopczyk/mergemate/pkg/gitlab"
-	"github.com/aprokopczyk/mergemate/ui/colors"
+	"github.com/aprokopczyk/mergemate/pkg/webhook"
 	"github.com/aprokopczyk/mergemate/ui/context"
+	"github.com/aprokopczyk/mergemate/ui/scheduler"
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/evertras/bubble-table/table"
-	"log"
-	"strings"
+	"time"
 )
 
 type MergedMergeRequestTable struct {
 	flexTable     table.Model
-	mergeRequests []gitlab.MergeRequestDetails
+	mergeRequests []projectMergeRequest
 	context       *context.AppContext
 }
 
 func NewMergedMergeRequestTable(context *context.AppContext) *MergedMergeRequestTable {
 	return &MergedMergeRequestTable{
 		flexTable: table.New([]table.Column{
+			table.NewFlexColumn(columnKeyProject, "Project", 1),
 			table.NewFlexColumn(columnKeyMergeRequest, "Merge request", 1),
 			table.NewFlexColumn(columnKeySourceBranch, "Source branch", 1),
 			table.NewFlexColumn(columnKeyTargetBranch, "Target branch", 1),
 		}).WithRows([]table.Row{}).Focused(true).
 			HeaderStyle(lipgloss.NewStyle().Bold(true)).
-			WithBaseStyle(lipgloss.NewStyle().Align(lipgloss.Left).BorderForeground(colors.Emerald600)).
+			WithBaseStyle(tableStyle(context)).
 			WithPageSize(context.TablePageSize),
 		context: context,
 	}
 }
 
-func (m *MergedMergeRequestTable) listMergeRequests() tea.Msg {
-	mergeRequests, err := m.context.GitlabClient.MergedMergeRequests()
-	if err != nil {
-		log.Printf("Error when fetching merge requests %v", err)
-	}
-	return mergeRequests
-}
-
-func (m *MergedMergeRequestTable) shouldBeMergedAutomatically(mergeRequestIid int) tea.Cmd {
-	return func() tea.Msg {
-		notes, err := m.context.GitlabClient.ListMergeRequestNotes(mergeRequestIid)
-		if err != nil {
-			log.Printf("Error when fetching merge request notes %v", err)
-		}
-		var shouldBeMergedAutomatically bool
-		for _, note := range notes {
-			if strings.HasPrefix(note.Body, MergeAutomatically) {
-				shouldBeMergedAutomatically = true
-				break
-			}
-		}
-		return MergeAutomaticallyStatus{
-			mergeRequestIid:             mergeRequestIid,
-			shouldBeMergedAutomatically: shouldBeMergedAutomatically,
-		}
-	}
+// MergedMergeRequests holds merged merge requests of every project, keyed by project name.
+type MergedMergeRequests struct {
+	MergeRequests map[string][]gitlab.MergeRequestDetails
+	StaleSince    time.Time
 }
 
 func (m *MergedMergeRequestTable) Init() tea.Cmd {
-	return m.listMergeRequests
+	return nil
 }
 
 func (m *MergedMergeRequestTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
@@ -74,12 +53,17 @@ func (m *MergedMergeRequestTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
 	cmds = append(cmds, cmd)
 
 	switch msg := msg.(type) {
-	case []gitlab.MergeRequestDetails:
-		m.mergeRequests = msg
+	case MergedMergeRequests:
+		m.mergeRequests = flattenMergeRequests(msg.MergeRequests)
 		m.redrawTable()
 		m.flexTable = m.flexTable.PageFirst()
+	case webhook.Event:
+		if msg.Kind == webhook.KindMergeRequest && msg.Action == "merge" {
+			cmds = append(cmds, scheduler.Trigger(MergedMergeRequestsJob))
+		}
 	case context.UpdatedContextMessage:
 		m.recalculateTable()
+		m.redrawTable()
 	}
 
 	return m, tea.Batch(cmds...)
@@ -88,7 +72,11 @@ func (m *MergedMergeRequestTable) Update(msg tea.Msg) (TabContent, tea.Cmd) {
 func (m *MergedMergeRequestTable) redrawTable() {
 	var rows []table.Row
 	for _, mergeRequest := range m.mergeRequests {
+		if !m.context.IsShown(mergeRequest.project) {
+			continue
+		}
 		rows = append(rows, table.NewRow(table.RowData{
+			columnKeyProject:              mergeRequest.project,
 			columnKeyMergeRequest:         mergeRequest.Title,
 			columnKeySourceBranch:         mergeRequest.SourceBranch,
 			columnKeyTargetBranch:         mergeRequest.TargetBranch,
@@ -101,6 +89,7 @@ func (m *MergedMergeRequestTable) redrawTable() {
 func (m *MergedMergeRequestTable) recalculateTable() {
 	m.flexTable = m.flexTable.WithTargetWidth(m.context.WindowWidth - m.context.Styles.Tabs.Content.GetHorizontalFrameSize())
 	m.flexTable = m.flexTable.WithPageSize(m.context.TablePageSize)
+	m.flexTable = m.flexTable.WithBaseStyle(tableStyle(m.context))
 }
 
 func (m *MergedMergeRequestTable) FullHelp() []key.Binding {
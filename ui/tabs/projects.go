@@ -0,0 +1,62 @@
+package tabs
+
+import (
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"sort"
+)
+
+const columnKeyProject = "project"
+
+// mergeRequestKey identifies merge request across projects, iids are unique only within a project.
+type mergeRequestKey struct {
+	project string
+	iid     int
+}
+
+type projectMergeRequest struct {
+	project string
+	gitlab.MergeRequestDetails
+}
+
+func (mergeRequest projectMergeRequest) key() mergeRequestKey {
+	return mergeRequestKey{project: mergeRequest.project, iid: mergeRequest.Iid}
+}
+
+type projectBranch struct {
+	project string
+	gitlab.Branch
+}
+
+// flattenMergeRequests merges lists of all projects, the newest merge requests go first.
+func flattenMergeRequests(mergeRequests map[string][]gitlab.MergeRequestDetails) []projectMergeRequest {
+	var result []projectMergeRequest
+	for project, projectMergeRequests := range mergeRequests {
+		for _, mergeRequest := range projectMergeRequests {
+			result = append(result, projectMergeRequest{project: project, MergeRequestDetails: mergeRequest})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].project < result[j].project
+		}
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	return result
+}
+
+// flattenBranches merges lists of all projects, branches with the most recent commits go first.
+func flattenBranches(branches map[string][]gitlab.Branch) []projectBranch {
+	var result []projectBranch
+	for project, projectBranches := range branches {
+		for _, branch := range projectBranches {
+			result = append(result, projectBranch{project: project, Branch: branch})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Commit.AuthoredDate.Equal(result[j].Commit.AuthoredDate) {
+			return result[i].project < result[j].project
+		}
+		return result[i].Commit.AuthoredDate.After(result[j].Commit.AuthoredDate)
+	})
+	return result
+}
@@ -1,8 +1,20 @@
 package tabs
 
 import (
+	"github.com/aprokopczyk/mergemate/ui/context"
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// names of background jobs, tabs receive their results and can trigger them with scheduler.Trigger
+const (
+	MergeJob               = "mergeJob"
+	ActiveMergeRequestsJob = "activeMergeRequests"
+	MergedMergeRequestsJob = "mergedMergeRequests"
+	UserBranchesJob        = "userBranches"
+	TargetBranchesJob      = "targetBranches"
+	ActionQueueJob         = "actionQueue"
 )
 
 type TabContent interface {
@@ -11,3 +23,8 @@ type TabContent interface {
 	View() string
 	FullHelp() []key.Binding
 }
+
+// tableStyle follows the theme, tables apply it again when context is updated as the theme can be reloaded.
+func tableStyle(context *context.AppContext) lipgloss.Style {
+	return lipgloss.NewStyle().Align(lipgloss.Left).BorderForeground(context.Styles.Theme.Primary)
+}
@@ -1,9 +1,12 @@
 package ui
 
 import (
-	"github.com/aprokopczyk/mergemate/ui/colors"
+	"fmt"
+	"github.com/aprokopczyk/mergemate/pkg/gitlab"
+	"github.com/aprokopczyk/mergemate/pkg/webhook"
 	"github.com/aprokopczyk/mergemate/ui/context"
 	"github.com/aprokopczyk/mergemate/ui/keys"
+	"github.com/aprokopczyk/mergemate/ui/scheduler"
 	"github.com/aprokopczyk/mergemate/ui/styles"
 	"github.com/aprokopczyk/mergemate/ui/tabs"
 	"github.com/charmbracelet/bubbles/help"
@@ -11,6 +14,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"strings"
+	"time"
 )
 
 const (
@@ -23,10 +27,14 @@ const (
 type UI struct {
 	tabs       []string
 	tabContent []tabs.TabContent
+	refreshJob []string
 	activeTab  int
 	actionLog  *tabs.ActionLog
 	help       help.Model
 	context    *context.AppContext
+	scheduler  *scheduler.Scheduler
+	// staleSince is set for jobs which show data saved on disk because gitlab couldn't be reached
+	staleSince map[string]time.Time
 }
 
 func New(context *context.AppContext) *UI {
@@ -35,32 +43,40 @@ func New(context *context.AppContext) *UI {
 	ui := &UI{
 		tabs:       make([]string, lastTab),
 		tabContent: make([]tabs.TabContent, lastTab),
+		refreshJob: make([]string, lastTab),
+		staleSince: make(map[string]time.Time),
 		activeTab:  activeMergeRequestsTab,
 		actionLog:  tabs.NewActionLog(context),
 		help:       helpModel,
 		context:    context,
 	}
+	ui.scheduler = scheduler.New(ui.backgroundJobs()...)
 
 	return ui
 }
 
-func (ui *UI) listTargetBranches() tea.Msg {
-	branches := ui.context.GitlabClient.FetchBranchesWithPattern(ui.context.TargetBranchPrefixes)
-	return tabs.TargetBranches{Branches: branches}
+func (ui *UI) waitForWebhookEvent() tea.Msg {
+	return <-ui.context.WebhookServer.Events()
 }
 
 func (ui *UI) Init() tea.Cmd {
 	cmds := make([]tea.Cmd, 0)
 	ui.tabs[activeMergeRequestsTab] = "Active merge requests"
 	ui.tabContent[activeMergeRequestsTab] = tabs.NewActiveMergeRequestTable(ui.context)
+	ui.refreshJob[activeMergeRequestsTab] = tabs.ActiveMergeRequestsJob
 	ui.tabs[branchesTab] = "Your branches"
 	ui.tabContent[branchesTab] = tabs.NewBranchTable(ui.context)
+	ui.refreshJob[branchesTab] = tabs.UserBranchesJob
 	ui.tabs[mergedMergeRequestsTab] = "Merged merge requests"
 	ui.tabContent[mergedMergeRequestsTab] = tabs.NewMergedMergeRequestTable(ui.context)
+	ui.refreshJob[mergedMergeRequestsTab] = tabs.MergedMergeRequestsJob
 	cmds = append(cmds, ui.tabContent[activeMergeRequestsTab].Init())
 	cmds = append(cmds, ui.tabContent[branchesTab].Init())
 	cmds = append(cmds, ui.tabContent[mergedMergeRequestsTab].Init())
-	cmds = append(cmds, ui.listTargetBranches)
+	cmds = append(cmds, ui.scheduler.Init())
+	if ui.context.WebhookServer != nil {
+		cmds = append(cmds, ui.waitForWebhookEvent)
+	}
 	return tea.Batch(cmds...)
 }
 
@@ -72,16 +88,22 @@ func (ui *UI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if cmd != nil {
 		cmds = append(cmds, cmd)
 	}
+	if cmd := ui.scheduler.Update(msg); cmd != nil {
+		cmds = append(cmds, cmd)
+	}
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch {
 		case key.Matches(msg, keys.Keys.Right):
 			ui.activeTab = min(ui.activeTab+1, len(ui.tabs)-1)
-			cmds = append(cmds, ui.tabContent[ui.activeTab].Init())
+			cmds = append(cmds, scheduler.Trigger(ui.refreshJob[ui.activeTab]))
 		case key.Matches(msg, keys.Keys.Left):
 			ui.activeTab = max(ui.activeTab-1, 0)
-			cmds = append(cmds, ui.tabContent[ui.activeTab].Init())
+			cmds = append(cmds, scheduler.Trigger(ui.refreshJob[ui.activeTab]))
+		case key.Matches(msg, keys.Keys.FilterProject):
+			ui.context.NextProjectFilter()
+			cmds = append(cmds, triggerOnAll(context.UpdatedContextMessage{}, ui)...)
 		case key.Matches(msg, keys.Keys.Quit):
 			cmds = append(cmds, tea.Quit)
 		}
@@ -100,6 +122,25 @@ func (ui *UI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		ui.help.Width = msg.Width
 		cmds = append(cmds, tea.ClearScreen)
 		cmds = append(cmds, triggerOnAll(context.UpdatedContextMessage{}, ui)...)
+	case context.ReloadedConfigMessage:
+		if msg.Apply != nil {
+			msg.Apply(ui.context)
+			cmds = append(cmds, triggerOnAll(context.UpdatedContextMessage{}, ui)...)
+			// keys and favourites change height of help
+			size := tea.WindowSizeMsg{Width: ui.context.WindowWidth, Height: ui.context.WindowHeight}
+			cmds = append(cmds, func() tea.Msg { return size })
+			for _, job := range []string{tabs.UserBranchesJob, tabs.TargetBranchesJob, tabs.MergeJob} {
+				cmds = append(cmds, scheduler.Trigger(job))
+			}
+		}
+	case webhook.Event:
+		cmds = append(cmds, ui.waitForWebhookEvent)
+	case tabs.ActiveMergeRequests:
+		ui.staleSince[tabs.ActiveMergeRequestsJob] = msg.StaleSince
+	case tabs.MergedMergeRequests:
+		ui.staleSince[tabs.MergedMergeRequestsJob] = msg.StaleSince
+	case tabs.UserBranches:
+		ui.staleSince[tabs.UserBranchesJob] = msg.StaleSince
 	}
 
 	// key message only to active tab, rest goes to all tabs
@@ -116,6 +157,23 @@ func (ui *UI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return ui, tea.Batch(cmds...)
 }
 
+func (ui *UI) connectionStatus() string {
+	status := []string{"gitlab " + string(ui.context.Health())}
+	if ui.context.InsecureConnection {
+		status = append(status, "TLS verification disabled")
+	}
+	if ui.context.ProjectFilter != "" {
+		status = append(status, "showing "+ui.context.ProjectFilter)
+	}
+	if staleSince := ui.staleSince[ui.refreshJob[ui.activeTab]]; !staleSince.IsZero() {
+		status = append(status, "stale since "+staleSince.Format("15:04"))
+	}
+	if queued := ui.context.ActionQueue.Len(); queued > 0 {
+		status = append(status, fmt.Sprintf("%d queued actions", queued))
+	}
+	return strings.Join(status, ", ")
+}
+
 func (ui *UI) getHelpHeight() int {
 	keyMap := keys.GetKeyMap(ui.tabContent[ui.activeTab].FullHelp())
 	height := 0
@@ -148,7 +206,7 @@ func (ui *UI) View() string {
 		isActive := i == ui.activeTab
 		isLast := i == len(ui.tabs)-1
 		if isActive {
-			style.Bold(true).Underline(true).Background(colors.Emerald600)
+			style.Bold(true).Underline(true).Background(styleDefinitions.Theme.Primary)
 		}
 		if isLast {
 			style.UnsetBorderRight()
@@ -156,6 +214,11 @@ func (ui *UI) View() string {
 
 		renderedTabs = append(renderedTabs, style.Render(t))
 	}
+	statusStyle := styleDefinitions.Tabs.ConnectionStatus.Copy()
+	if ui.context.Health() != gitlab.HealthConnected || ui.context.InsecureConnection {
+		statusStyle.Foreground(styleDefinitions.Theme.Warning)
+	}
+	renderedTabs = append(renderedTabs, statusStyle.Render(ui.connectionStatus()))
 	toRender.WriteString(styleDefinitions.Tabs.Header.Copy().Width(ui.context.WindowWidth).Render(lipgloss.JoinHorizontal(lipgloss.Top, renderedTabs...)))
 	toRender.WriteString("\n")
 	tabsContent := styleDefinitions.Tabs.Content.Copy().Width(ui.context.WindowWidth).Render(ui.tabContent[ui.activeTab].View())